@@ -10,16 +10,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 
+	cfg "github.com/1F47E/go-bitreel/internal/config"
 	"github.com/1F47E/go-bitreel/internal/core"
 	"github.com/1F47E/go-bitreel/internal/logger"
 	"github.com/1F47E/go-bitreel/internal/printer"
 	"github.com/1F47E/go-bitreel/internal/tui"
+	"github.com/1F47E/go-bitreel/internal/video"
 
 	"github.com/urfave/cli"
 )
@@ -43,7 +49,6 @@ func init() {
 
 func main() {
 	log := logger.Log
-	printer.Banner()
 
 	// profiling
 	flag.Parse()
@@ -70,14 +75,29 @@ func main() {
 		stop := make(chan os.Signal, 1)
 		signal.Notify(stop, os.Interrupt)
 		<-stop
-		fmt.Println("Shutting down...")
+		log.Info("Shutting down...")
 		cancel()
 	}()
 
-	// TUI setup
+	// TUI setup, started by the command in the mode of the progress flag
 	tuiEventsCh := make(chan tui.Event)
-	t := tui.New(tuiEventsCh, ctx)
-	go t.Run()
+	t := tui.New(tuiEventsCh, ctx, cancel)
+	started := false
+	startProgress := func(c *cli.Context) error {
+		mode, err := tui.ParseMode(c.String("progress"))
+		if err != nil {
+			return err
+		}
+		mode = mode.Resolve()
+		if mode == tui.ModeTUI {
+			printer.Banner()
+		} else {
+			logger.SetPlain(mode == tui.ModeJSON)
+		}
+		started = true
+		go t.Run(mode)
+		return nil
+	}
 
 	// pass events channel to send all the events to the TUI
 	appCore := core.NewCore(ctx, tuiEventsCh)
@@ -88,7 +108,12 @@ func main() {
 		if err != nil {
 			return err
 		}
-		return appCore.Encode(filename)
+		opts, err := encodeOptions(c)
+		if err != nil {
+			return err
+		}
+		_, err = appCore.Encode(filename, opts)
+		return err
 	}
 
 	// on decode command
@@ -97,7 +122,15 @@ func main() {
 		if err != nil {
 			return err
 		}
-		_, err = appCore.Decode(filename)
+		// volumes of a split reel in any order
+		inputs := append([]string{filename}, c.Args().Tail()...)
+		_, err = appCore.Decode(inputs, core.DecodeOptions{
+			Optical: c.Bool("optical"),
+			Backend: c.String("backend"),
+			Resume:  c.Bool("resume"),
+			Window:  c.Int("window"),
+			Limits:  limits(c),
+		})
 		return err
 	}
 
@@ -107,7 +140,11 @@ func main() {
 		if err != nil {
 			return err
 		}
-		same, err := appCore.Compare(filename)
+		opts, err := encodeOptions(c)
+		if err != nil {
+			return err
+		}
+		same, err := appCore.Compare(filename, opts)
 		if err != nil {
 			return fmt.Errorf("Error comparing video: %v", err)
 		}
@@ -118,13 +155,169 @@ func main() {
 		return nil
 	}
 
+	// on info command
+	fInfo := func(c *cli.Context) error {
+		filename, err := getFilename(c)
+		if err != nil {
+			return err
+		}
+		summary, source, err := appCore.Info(filename, core.DecodeOptions{
+			Backend: c.String("backend"),
+		})
+		if err != nil {
+			return err
+		}
+		if c.Bool("json") {
+			fmt.Println(summary.JSON())
+			return nil
+		}
+		log.Infof("Reel info from %s\n%s", source, summary.Print())
+		return nil
+	}
+
+	// on bench command
+	fBench := func(c *cli.Context) error {
+		workers, err := parseWorkers(c.String("workers"))
+		if err != nil {
+			return err
+		}
+		report, err := appCore.Bench(core.BenchOptions{
+			Frames:  c.Int("frames"),
+			Workers: workers,
+			Backend: c.String("backend"),
+			Limits:  limits(c),
+		})
+		if err != nil {
+			return err
+		}
+		if c.Bool("json") {
+			data, err := json.Marshal(report)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		log.Infof("Bench\n%s", report.Print())
+		return nil
+	}
+
+	encodeFlags := []cli.Flag{
+		cli.IntFlag{
+			Name:  "block, b",
+			Usage: fmt.Sprintf("block size in pixels, use 4+ if the host may downscale the video (default %d, %d for pdf)", cfg.FrameBlock, cfg.PageBlock),
+		},
+		cli.BoolTFlag{
+			Name:  "scramble",
+			Usage: "whiten the data to avoid flat areas on sparse files, --scramble=false to disable",
+		},
+		cli.IntFlag{
+			Name:  "interleave",
+			Usage: "interleaver depth, spreads neighbour bytes within a frame so codec block damage is scattered, 0 - off. No error correction, a damaged frame stays damaged",
+		},
+		cli.BoolFlag{
+			Name:  "audio",
+			Usage: "keep a copy of the header and the frames index in the audio track",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: fmt.Sprintf("output path (default %s, %s for the native backend, %s for images, %s for pdf, %s for gif)", cfg.PathVideoOut, cfg.PathVideoOutNative, cfg.PathImagesOut, cfg.PathPDFOut, cfg.PathGIFOut),
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: core.FormatVideo,
+			Usage: "video, images - numbered png frames in a dir, or a zip if the output ends with .zip, pdf - printable pages with big blocks, gif - animated GIF without ffmpeg",
+		},
+		cli.IntFlag{
+			Name:  "max-frames",
+			Usage: "split the reel into volumes of at most this many frames: reel.001.mov, reel.002.mov...",
+		},
+		cli.StringFlag{
+			Name:  "max-size",
+			Usage: "split the reel into volumes of at most this size, bytes or with K, M, G, T suffix",
+		},
+		cli.BoolFlag{
+			Name:  "resume",
+			Usage: "continue the interrupted encode of the same file with the same settings",
+		},
+		cli.BoolFlag{
+			Name:  "sidecar",
+			Usage: "write the reel summary to a .bitreel.json file next to the video",
+		},
+	}
+
+	decodeFlags := []cli.Flag{
+		cli.BoolFlag{
+			Name:  "optical",
+			Usage: "decode a camera or screen recording of a playing reel",
+		},
+		cli.BoolFlag{
+			Name:  "resume",
+			Usage: "continue the interrupted decode, or patch the damaged frames of the decoded file from another copy",
+		},
+		cli.IntFlag{
+			Name:  "window",
+			Usage: "frames decoded ahead of the writer, bounds the memory on long reels (default 2 per CPU)",
+		},
+	}
+
+	infoFlags := []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the summary as json",
+		},
+	}
+
+	// shared build servers
+	limitsFlags := []cli.Flag{
+		cli.IntFlag{
+			Name:  "jobs, j",
+			Usage: "frame workers (default one per CPU, half of the CPUs with --nice)",
+		},
+		cli.IntFlag{
+			Name:  "ffmpeg-threads",
+			Usage: "threads per ffmpeg run (default ffmpeg decides, as many as the workers with --nice)",
+		},
+		cli.BoolFlag{
+			Name:  "nice",
+			Usage: "lower the CPU and IO priority of bitreel and ffmpeg and limit the bitreel heap to 1GiB",
+		},
+	}
+	encodeFlags = append(encodeFlags, limitsFlags...)
+	decodeFlags = append(decodeFlags, limitsFlags...)
+
+	benchFlags := append([]cli.Flag{
+		cli.IntFlag{
+			Name:  "frames",
+			Value: 32,
+			Usage: "frames of synthetic data",
+		},
+		cli.StringFlag{
+			Name:  "workers",
+			Usage: "worker counts to run the frame stages with: 1,4,8 (default powers of 2 up to the CPUs)",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the report as json",
+		},
+	}, limitsFlags...)
+
 	app.Commands = []cli.Command{
-		cmdBuilder("encode", "e", "Encode a file", fEncode),
-		cmdBuilder("decode", "d", "Decode a video", fDecode),
-		cmdBuilder("test", "t", "Run encode+decode and compare files", fCompare),
+		cmdBuilder("encode", "e", "Encode a file", fEncode, startProgress, encodeFlags...),
+		cmdBuilder("decode", "d", "Decode a video, a pdf, a gif, a dir, glob or zip of frames, or all the volumes of a split reel", fDecode, startProgress, decodeFlags...),
+		cmdBuilder("info", "i", "Show reel info without decoding", fInfo, startProgress, infoFlags...),
+		cmdBuilder("test", "t", "Run encode+decode and compare files", fCompare, startProgress, encodeFlags...),
+		cmdBuilder("bench", "b", "Measure the throughput of every pipeline stage on synthetic data", fBench, startProgress, benchFlags...),
 	}
 
 	err := app.Run(args)
+	if started {
+		// the last events are written before the exit
+		t.Stop()
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Fatal("Cancelled")
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -138,11 +331,101 @@ func getFilename(c *cli.Context) (string, error) {
 	return f, nil
 }
 
-func cmdBuilder(name, alias, descr string, f func(c *cli.Context) error) cli.Command {
+func encodeOptions(c *cli.Context) (core.EncodeOptions, error) {
+	maxSize, err := parseSize(c.String("max-size"))
+	if err != nil {
+		return core.EncodeOptions{}, err
+	}
+	return core.EncodeOptions{
+		Block:      c.Int("block"),
+		Scramble:   c.BoolT("scramble"),
+		Interleave: c.Int("interleave"),
+		Audio:      c.Bool("audio"),
+		Sidecar:    c.Bool("sidecar"),
+		Output:     c.String("output"),
+		Format:     c.String("format"),
+		Backend:    c.String("backend"),
+		MaxFrames:  c.Int("max-frames"),
+		MaxSize:    maxSize,
+		Resume:     c.Bool("resume"),
+		Limits:     limits(c),
+	}, nil
+}
+
+func limits(c *cli.Context) core.Limits {
+	return core.Limits{
+		Jobs:          c.Int("jobs"),
+		FFmpegThreads: c.Int("ffmpeg-threads"),
+		Nice:          c.Bool("nice"),
+	}
+}
+
+// parseSize parses bytes with an optional binary suffix: 700M, 4G, 0 if empty
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	units := map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	num := strings.TrimSuffix(strings.ToUpper(s), "B")
+	if num == "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	mul := int64(1)
+	if u, ok := units[num[len(num)-1]]; ok {
+		mul = u
+		num = num[:len(num)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mul, nil
+}
+
+// parseWorkers parses a comma separated list of worker counts, nil if empty
+func parseWorkers(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var res []int
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid workers count %q", part)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+// every command runs the video backend
+var (
+	ffmpegFlag = cli.StringFlag{
+		Name:  "ffmpeg",
+		Usage: fmt.Sprintf("path to the ffmpeg binary, ffprobe is looked up next to it (default from %s or PATH)", video.EnvFFmpeg),
+	}
+	backendFlag = cli.StringFlag{
+		Name:  "backend",
+		Value: video.BackendAuto,
+		Usage: "video backend: ffmpeg, native - pure Go lossless AVI, no ffmpeg required, auto - ffmpeg if found",
+	}
+	progressFlag = cli.StringFlag{
+		Name:  "progress",
+		Value: string(tui.ModeAuto),
+		Usage: "progress output: tui, json - an event per line on stderr, plain - a text line per event on stderr, none, auto - tui on a terminal, plain otherwise",
+	}
+)
+
+func cmdBuilder(name, alias, descr string, f, before func(c *cli.Context) error, flags ...cli.Flag) cli.Command {
 	return cli.Command{
 		Name:    name,
 		Aliases: []string{alias},
 		Usage:   descr,
-		Action:  f,
+		Before: func(c *cli.Context) error {
+			video.SetFFmpeg(c.String("ffmpeg"))
+			return before(c)
+		},
+		Action: f,
+		Flags:  append(flags, ffmpegFlag, backendFlag, progressFlag),
 	}
 }
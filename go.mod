@@ -8,6 +8,8 @@ require (
 	github.com/charmbracelet/lipgloss v0.7.1
 	github.com/sirupsen/logrus v1.9.3
 	github.com/urfave/cli v1.22.14
+	golang.org/x/sync v0.1.0
+	golang.org/x/term v0.6.0
 )
 
 require (
@@ -25,8 +27,6 @@ require (
 	github.com/muesli/termenv v0.15.1 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
-	golang.org/x/term v0.6.0 // indirect
 	golang.org/x/text v0.3.8 // indirect
 )
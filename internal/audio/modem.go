@@ -0,0 +1,167 @@
+// Binary FSK modem to carry data in the audio track of a reel.
+// Audio is muxed as PCM so the signal is not damaged by a lossy audio codec,
+// but re-encoded audio still decodes as long as the tones survive.
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+)
+
+const (
+	SampleRate = 48000
+	baud       = 4800
+	// samples per bit
+	symbolLen = SampleRate / baud
+	// whole number of periods per bit, so the phase is continuous
+	freqZero = baud
+	freqOne  = baud * 2
+
+	amplitude    = 0.5 * math.MaxInt16
+	preambleBits = 64
+)
+
+var syncWord = []byte{0x7E, 'B', 'R', 'L'}
+
+// Modulate encodes data into a packet and repeats it until the duration is filled,
+// at least once. Packet: preamble, sync word, length, data, crc32.
+func Modulate(data []byte, duration float64) []int16 {
+	packet := make([]byte, 0, len(syncWord)+8+len(data))
+	packet = append(packet, syncWord...)
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(data)))
+	packet = append(packet, data...)
+	packet = binary.BigEndian.AppendUint32(packet, crc32.ChecksumIEEE(data))
+
+	packetLen := (preambleBits + len(packet)*8) * symbolLen
+	copies := int(duration*SampleRate) / packetLen
+	if copies < 1 {
+		copies = 1
+	}
+	samples := make([]int16, 0, copies*packetLen)
+	for i := 0; i < copies; i++ {
+		for b := 0; b < preambleBits; b++ {
+			samples = appendSymbol(samples, b%2 == 1)
+		}
+		for _, by := range packet {
+			for k := 0; k < 8; k++ {
+				samples = appendSymbol(samples, by&(1<<uint(k)) != 0)
+			}
+		}
+	}
+	return samples
+}
+
+func appendSymbol(samples []int16, bit bool) []int16 {
+	f := float64(freqZero)
+	if bit {
+		f = freqOne
+	}
+	for n := 0; n < symbolLen; n++ {
+		samples = append(samples, int16(amplitude*math.Sin(2*math.Pi*f*float64(n)/SampleRate)))
+	}
+	return samples
+}
+
+// Demodulate finds the first packet with a valid checksum
+func Demodulate(samples []int16) ([]byte, error) {
+	for _, offset := range symbolOffsets(samples) {
+		bits := demodBits(samples[offset:])
+		if data, ok := findPacket(bits); ok {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("no valid packet found in %d samples", len(samples))
+}
+
+// symbolOffsets returns the possible symbol timings, most likely first.
+// Timing is unknown after trimming. Aligned symbols have one tone clearly
+// stronger than the other, misaligned ones mix two symbols.
+func symbolOffsets(samples []int16) []int {
+	const probe = 4096 // symbols
+	offsets := make([]int, symbolLen)
+	scores := make([]float64, symbolLen)
+	for offset := 0; offset < symbolLen; offset++ {
+		var score float64
+		for i := 0; i < probe; i++ {
+			start := offset + i*symbolLen
+			if start+symbolLen > len(samples) {
+				break
+			}
+			symbol := samples[start : start+symbolLen]
+			e0, e1 := toneEnergy(symbol, freqZero), toneEnergy(symbol, freqOne)
+			if e0+e1 > 0 {
+				score += math.Abs(e1-e0) / (e1 + e0)
+			}
+		}
+		offsets[offset] = offset
+		scores[offset] = score
+	}
+	sort.Slice(offsets, func(i, j int) bool { return scores[offsets[i]] > scores[offsets[j]] })
+	return offsets
+}
+
+// demodBits compares the energy of both tones in every symbol
+func demodBits(samples []int16) []bool {
+	bits := make([]bool, len(samples)/symbolLen)
+	for i := range bits {
+		symbol := samples[i*symbolLen : (i+1)*symbolLen]
+		bits[i] = toneEnergy(symbol, freqOne) > toneEnergy(symbol, freqZero)
+	}
+	return bits
+}
+
+func toneEnergy(symbol []int16, freq float64) float64 {
+	var re, im float64
+	for n, s := range symbol {
+		phase := 2 * math.Pi * freq * float64(n) / SampleRate
+		re += float64(s) * math.Cos(phase)
+		im += float64(s) * math.Sin(phase)
+	}
+	return re*re + im*im
+}
+
+func findPacket(bits []bool) ([]byte, bool) {
+	byteAt := func(pos int) byte {
+		var b byte
+		for k := 0; k < 8; k++ {
+			if bits[pos+k] {
+				b |= 1 << uint(k)
+			}
+		}
+		return b
+	}
+	readBytes := func(pos, n int) []byte {
+		res := make([]byte, n)
+		for i := range res {
+			res[i] = byteAt(pos + i*8)
+		}
+		return res
+	}
+	headerBits := (len(syncWord) + 4) * 8
+	for pos := 0; pos+headerBits <= len(bits); pos++ {
+		match := true
+		for i, s := range syncWord {
+			if byteAt(pos+i*8) != s {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		size := int(binary.BigEndian.Uint32(readBytes(pos+len(syncWord)*8, 4)))
+		end := pos + headerBits + (size+4)*8
+		if size < 0 || end > len(bits) {
+			continue
+		}
+		data := readBytes(pos+headerBits, size)
+		crc := binary.BigEndian.Uint32(readBytes(pos+headerBits+size*8, 4))
+		if crc == crc32.ChecksumIEEE(data) {
+			return data, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,88 @@
+package audio
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func testPayload(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+// flipBit replaces the symbol of the bit with the other tone
+func flipBit(samples []int16, bit int) {
+	start := bit * symbolLen
+	symbol := appendSymbol(nil, !isOne(samples[start:start+symbolLen]))
+	copy(samples[start:], symbol)
+}
+
+func isOne(symbol []int16) bool {
+	return toneEnergy(symbol, freqOne) > toneEnergy(symbol, freqZero)
+}
+
+func TestModemRoundTrip(t *testing.T) {
+	data := testPayload(1000)
+	samples := Modulate(data, 0)
+	// trimmed by the muxer, the symbols are not aligned to the start
+	for _, trim := range []int{0, 1, symbolLen / 2, symbolLen*3 + 7} {
+		got, err := Demodulate(samples[trim:])
+		if err != nil {
+			t.Fatalf("trim %d: %v", trim, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("trim %d: data differs", trim)
+		}
+	}
+}
+
+func TestModemCorruptedHeader(t *testing.T) {
+	data := testPayload(200)
+	packetBits := preambleBits + (len(syncWord)+4+len(data)+4)*8
+	lengthBit := preambleBits + len(syncWord)*8
+	tests := []struct {
+		name string
+		// bits flipped in the first copy
+		bits []int
+	}{
+		{"sync word", []int{preambleBits + 3}},
+		{"length", []int{lengthBit + 2}},
+		{"length over the samples", []int{lengthBit + 7}},
+		{"data", []int{lengthBit + 32 + 100}},
+		{"crc", []int{packetBits - 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := Modulate(data, 0)
+			samples = append(samples, Modulate(data, 0)...)
+			for _, bit := range tt.bits {
+				flipBit(samples, bit)
+			}
+			// the second copy
+			got, err := Demodulate(samples)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("data differs")
+			}
+
+			// the only copy
+			_, err = Demodulate(samples[:packetBits*symbolLen])
+			if err == nil {
+				t.Fatal("corrupted packet decoded")
+			}
+		})
+	}
+}
+
+func TestModulateDuration(t *testing.T) {
+	data := testPayload(100)
+	packetLen := len(Modulate(data, 0))
+	samples := Modulate(data, 10*float64(packetLen)/SampleRate)
+	if len(samples) != 10*packetLen {
+		t.Fatalf("%d samples, want %d packets of %d", len(samples), 10, packetLen)
+	}
+}
@@ -0,0 +1,79 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// WriteWav saves 16 bit mono PCM samples
+func WriteWav(filename string, samples []int16) error {
+	dataLen := uint32(len(samples) * 2)
+	header := make([]byte, 0, 44)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, 36+dataLen)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, 1) // PCM
+	header = binary.LittleEndian.AppendUint16(header, 1) // mono
+	header = binary.LittleEndian.AppendUint32(header, SampleRate)
+	header = binary.LittleEndian.AppendUint32(header, SampleRate*2)
+	header = binary.LittleEndian.AppendUint16(header, 2)
+	header = binary.LittleEndian.AppendUint16(header, 16)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, dataLen)
+
+	buf := make([]byte, len(header)+int(dataLen))
+	copy(buf, header)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(buf[len(header)+i*2:], uint16(s))
+	}
+	return os.WriteFile(filename, buf, 0644)
+}
+
+// ReadWav reads 16 bit mono PCM samples, as extracted by ffmpeg with -ac 1 -ar 48000
+func ReadWav(filename string) ([]int16, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 12 || string(buf[:4]) != "RIFF" || string(buf[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a wav file: %s", filename)
+	}
+	// walk the chunks, ffmpeg adds LIST chunks before the data
+	var format bool
+	for pos := 12; pos+8 <= len(buf); {
+		id := string(buf[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(buf[pos+4 : pos+8]))
+		body := buf[pos+8:]
+		// streamed wav has unknown size
+		if size > len(body) || size < 0 {
+			size = len(body)
+		}
+		body = body[:size]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, fmt.Errorf("broken wav format chunk")
+			}
+			channels := binary.LittleEndian.Uint16(body[2:4])
+			rate := binary.LittleEndian.Uint32(body[4:8])
+			bits := binary.LittleEndian.Uint16(body[14:16])
+			if channels != 1 || rate != SampleRate || bits != 16 {
+				return nil, fmt.Errorf("unsupported wav: %d channels, %d Hz, %d bits", channels, rate, bits)
+			}
+			format = true
+		case "data":
+			if !format {
+				return nil, fmt.Errorf("wav data before format")
+			}
+			samples := make([]int16, len(body)/2)
+			for i := range samples {
+				samples[i] = int16(binary.LittleEndian.Uint16(body[i*2:]))
+			}
+			return samples, nil
+		}
+		pos += 8 + size + size%2
+	}
+	return nil, fmt.Errorf("no data in wav file: %s", filename)
+}
@@ -5,6 +5,12 @@ const (
 	FrameWidth    = 3840
 	FrameHeight   = 2160
 	FrameFileSize = 7684000 // estimated
+	FrameBlock    = 2       // default block size in pixels, every bit is a FrameBlock x FrameBlock square
+
+	// paper page, portrait, about 225 dpi on A4
+	PageWidth  = 1600
+	PageHeight = 2240
+	PageBlock  = 16 // about 1.8mm on paper
 
 	// all sizes are in bytes
 	SizeFrameWidth  = 3840
@@ -14,7 +20,8 @@ const (
 	SizeMetadata    = 256
 
 	// meta
-	MetadataMaxFilenameLen       = 524 // size left in the meta header
+	MetadataVersion              = 1
+	MetadataMaxFilenameLen       = 192 // size left in the meta header, with the EOF marker
 	MetadataEOFMarker            = "/"
 	MetadataFilenameCutDelimeter = "--"
 
@@ -26,4 +33,18 @@ const (
 	// Path
 	PathFramesDir = "tmp/frames"
 	PathVideoOut  = "tmp/out.mov"
+	// native backend writes AVI
+	PathVideoOutNative = "tmp/out.avi"
+	// images format writes numbered png frames
+	PathImagesOut = "tmp/images"
+	PathPDFOut    = "tmp/out.pdf"
+	PathGIFOut    = "tmp/out.gif"
+	PathAudio     = "tmp/audio.wav"
+	// state of the last encode for --resume
+	PathJournal = "tmp/encode.journal.json"
+	// output and state of the last decode for --resume
+	PathDecodePart    = "tmp/decode.part"
+	PathDecodeJournal = "tmp/decode.journal.json"
+	// synthetic data and the video of the bench
+	PathBenchDir = "tmp/bench"
 )
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/1F47E/go-bitreel/internal/audio"
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/logger"
+	"github.com/1F47E/go-bitreel/internal/meta"
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// writeAudio modulates the manifest into a wav file for the audio track,
+// repeated for the whole video length
+func (c *Core) writeAudio(m *meta.Manifest) (string, error) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	duration := float64(len(m.Checksums)) / video.Framerate
+	samples := audio.Modulate(data, duration)
+	err = audio.WriteWav(cfg.PathAudio, samples)
+	if err != nil {
+		return "", fmt.Errorf("error writing audio: %w", err)
+	}
+	return cfg.PathAudio, nil
+}
+
+// readAudio demodulates the manifest from the audio track.
+// Reels without the audio channel return nil.
+func (c *Core) readAudio(backend video.Backend, videoFile string) *meta.Manifest {
+	log := logger.Log.WithField("scope", "core audio")
+	defer os.Remove(cfg.PathAudio)
+
+	err := backend.ExtractAudio(c.ctx, videoFile, cfg.PathAudio, audio.SampleRate)
+	if err != nil {
+		log.Debugf("no audio track: %v", err)
+		return nil
+	}
+	samples, err := audio.ReadWav(cfg.PathAudio)
+	if err != nil {
+		log.Warnf("cannot read audio track: %v", err)
+		return nil
+	}
+	data, err := audio.Demodulate(samples)
+	if err != nil {
+		log.Debugf("no manifest in audio: %v", err)
+		return nil
+	}
+	var m meta.Manifest
+	err = m.UnmarshalBinary(data)
+	if err != nil {
+		log.Warnf("broken manifest in audio: %v", err)
+		return nil
+	}
+	log.Debugf("manifest from audio: %s, %d frames", m.Meta.Print(), len(m.Checksums))
+	return &m
+}
@@ -0,0 +1,375 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/encoder"
+	"github.com/1F47E/go-bitreel/internal/meta"
+	"github.com/1F47E/go-bitreel/internal/storage"
+	"github.com/1F47E/go-bitreel/internal/tui"
+	"github.com/1F47E/go-bitreel/internal/video"
+	"golang.org/x/sync/errgroup"
+)
+
+// Pipeline stages in the order of the round trip
+const (
+	StageRead        = "read"
+	StageFrameEncode = "frame encode"
+	StageFrameWrite  = "frame write"
+	StageVideoEncode = "video encode"
+	StageExtract     = "extract"
+	StageFrameDecode = "frame decode"
+	StageWrite       = "write"
+)
+
+type BenchOptions struct {
+	// frames of synthetic data, 0 - 32
+	Frames int
+	// worker counts to run the frame stages with, empty - powers of 2 up to the CPUs
+	Workers []int
+	// video backend: auto, ffmpeg or native
+	Backend string
+	Limits
+}
+
+// BenchReport of the stages, the frame stages are run with every worker count
+type BenchReport struct {
+	Backend   string       `json:"backend"`
+	CPUs      int          `json:"cpus"`
+	Frames    int          `json:"frames"`
+	FrameSize int          `json:"frame_size"` // data bytes per frame
+	Stages    []BenchStage `json:"stages"`
+}
+
+type BenchStage struct {
+	Stage   string  `json:"stage"`
+	Workers int     `json:"workers,omitempty"`
+	Seconds float64 `json:"seconds"`
+	// file data through the stage
+	MBps float64 `json:"mb_per_sec"`
+	// time of one frame, by a worker in the frame stages
+	FrameMs float64 `json:"frame_ms"`
+}
+
+func (r *BenchReport) Print() string {
+	lines := []string{
+		fmt.Sprintf("Backend: %s, CPUs: %d, frames: %d of %d bytes", r.Backend, r.CPUs, r.Frames, r.FrameSize),
+		fmt.Sprintf("%-14s %7s %10s %10s", "Stage", "Workers", "MB/s", "ms/frame"),
+	}
+	for _, s := range r.Stages {
+		workers := "-"
+		if s.Workers > 0 {
+			workers = fmt.Sprint(s.Workers)
+		}
+		lines = append(lines, fmt.Sprintf("%-14s %7s %10.1f %10.1f", s.Stage, workers, s.MBps, s.FrameMs))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Bench runs synthetic data through every stage of the encode and the decode
+// and measures them one by one, the frame stages with every worker count.
+// Frames are written to the encoder work dir, the rest is in the bench dir, both are removed after.
+func (c *Core) Bench(opts BenchOptions) (*BenchReport, error) {
+	opts.apply()
+	if opts.Frames <= 0 {
+		opts.Frames = 32
+	}
+	workers := opts.Workers
+	if len(workers) == 0 {
+		workers = benchWorkers(opts.workers())
+	}
+	// frames of an interrupted encode are in the same dir
+	if _, err := os.Stat(cfg.PathJournal); err == nil {
+		return nil, fmt.Errorf("an interrupted encode is in the work dir, finish it with --resume or remove %s", cfg.PathJournal)
+	}
+	backend, err := c.newBackend(opts.Backend, "", opts.Limits, encodeEncoders, encodePixFmts)
+	if err != nil {
+		return nil, err
+	}
+	if backend.Name() == video.BackendFFmpeg {
+		err = c.requireFFmpeg(decodeEncoders, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	enc, err := encoder.NewFrameEncoder(cfg.FrameWidth, cfg.FrameHeight, cfg.FrameBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.RemoveAll("tmp/out")
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(cfg.PathBenchDir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(cfg.PathBenchDir)
+	defer os.RemoveAll("tmp/out")
+
+	b := &bench{
+		Core:    c,
+		enc:     enc,
+		backend: backend,
+		report: &BenchReport{
+			Backend:   backend.Name(),
+			CPUs:      runtime.NumCPU(),
+			Frames:    opts.Frames,
+			FrameSize: enc.Capacity() - cfg.SizeMetadata,
+		},
+	}
+	err = b.read(opts.Frames)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range workers {
+		err = b.encodeFrames(w)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = b.encodeVideo()
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range workers {
+		err = b.decodeFrames(w)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = b.write()
+	if err != nil {
+		return nil, err
+	}
+	return b.report, nil
+}
+
+// benchWorkers returns 1, 2, 4... up to n, and n
+func benchWorkers(n int) []int {
+	var res []int
+	for w := 1; w < n; w *= 2 {
+		res = append(res, w)
+	}
+	return append(res, n)
+}
+
+// bench keeps the data between the stages
+type bench struct {
+	*Core
+	enc     *encoder.FrameEncoder
+	backend video.Backend
+	report  *BenchReport
+	// synthetic file by frames, the decoded data by frames
+	chunks  [][]byte
+	decoded [][]byte
+	video   string
+	frames  []string
+}
+
+func (b *bench) add(stage string, workers int, wall, busy time.Duration) {
+	size := int64(len(b.chunks)) * int64(b.report.FrameSize)
+	b.report.Stages = append(b.report.Stages, BenchStage{
+		Stage:   stage,
+		Workers: workers,
+		Seconds: wall.Seconds(),
+		MBps:    float64(size) / (1 << 20) / wall.Seconds(),
+		FrameMs: busy.Seconds() * 1000 / float64(len(b.chunks)),
+	})
+}
+
+// read the synthetic file by frames, as the encoder does
+func (b *bench) read(frames int) error {
+	b.eventsCh <- tui.NewEventStage("Read")
+	path := filepath.Join(cfg.PathBenchDir, "in.bin")
+	data := make([]byte, frames*b.report.FrameSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	err := os.WriteFile(path, data, 0644)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	start := time.Now()
+	for i := 0; i < frames; i++ {
+		chunk := make([]byte, b.report.FrameSize)
+		_, err = io.ReadFull(f, chunk)
+		if err != nil {
+			return err
+		}
+		b.chunks = append(b.chunks, chunk)
+	}
+	wall := time.Since(start)
+	b.add(StageRead, 0, wall, wall)
+	return nil
+}
+
+func (b *bench) metadata(i int) meta.Metadata {
+	m := meta.New("bench.bin")
+	m.SetFlag(meta.FlagScrambled)
+	m.SetFrame(i + 1)
+	m.SetTotal(len(b.chunks))
+	m.SetSize(b.report.FrameSize)
+	return m
+}
+
+// encodeFrames encodes all the frames, then saves them, every stage is timed apart
+func (b *bench) encodeFrames(workers int) error {
+	b.eventsCh <- tui.NewEventStage(fmt.Sprintf("Frame encode, %d workers", workers))
+	imgs := make([]*image.Gray, len(b.chunks))
+	wall, busy, err := b.parallel(workers, func(i int) error {
+		img, err := b.enc.EncodeFrame(b.chunks[i], b.metadata(i))
+		imgs[i] = img
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	b.add(StageFrameEncode, workers, wall, busy)
+
+	b.eventsCh <- tui.NewEventStage(fmt.Sprintf("Frame write, %d workers", workers))
+	wall, busy, err = b.parallel(workers, func(i int) error {
+		err := storage.SaveFrame(i+1, imgs[i])
+		b.enc.ReleaseFrame(imgs[i])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	b.add(StageFrameWrite, workers, wall, busy)
+	return nil
+}
+
+// encodeVideo encodes the saved frames and extracts them back
+func (b *bench) encodeVideo() error {
+	b.video = filepath.Join(cfg.PathBenchDir, filepath.Base(defaultOutput(FormatVideo, b.backend.Name())))
+	b.eventsCh <- tui.NewEventStage("Video encode")
+	start := time.Now()
+	err := b.backend.EncodeFrames(b.ctx, b.video, "", nil, b.videoProgress(len(b.chunks)))
+	if err != nil {
+		return fmt.Errorf("Error encoding video: %w", err)
+	}
+	wall := time.Since(start)
+	b.add(StageVideoEncode, 0, wall, wall)
+
+	dir := filepath.Join(cfg.PathBenchDir, "frames")
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	b.eventsCh <- tui.NewEventStage("Extract")
+	start = time.Now()
+	err = b.backend.ExtractFrames(b.ctx, b.video, dir, b.videoProgress(len(b.chunks)))
+	if err != nil {
+		return fmt.Errorf("Error extracting frames: %w", err)
+	}
+	wall = time.Since(start)
+	b.add(StageExtract, 0, wall, wall)
+	b.frames, err = storage.ListFrames(dir)
+	if err != nil {
+		return err
+	}
+	if len(b.frames) != len(b.chunks) {
+		return fmt.Errorf("%d frames extracted, %d encoded", len(b.frames), len(b.chunks))
+	}
+	return nil
+}
+
+// decodeFrames decodes the extracted frames and checks them against the synthetic file
+func (b *bench) decodeFrames(workers int) error {
+	b.eventsCh <- tui.NewEventStage(fmt.Sprintf("Frame decode, %d workers", workers))
+	b.decoded = make([][]byte, len(b.frames))
+	wall, busy, err := b.parallel(workers, func(i int) error {
+		data, n, _, err := b.enc.DecodeFrame(b.frames[i])
+		if err != nil {
+			return err
+		}
+		if n < cfg.SizeMetadata || !bytes.Equal(data[cfg.SizeMetadata:n], b.chunks[i]) {
+			return fmt.Errorf("frame %d is decoded wrong", i+1)
+		}
+		b.decoded[i] = data[cfg.SizeMetadata:n]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.add(StageFrameDecode, workers, wall, busy)
+	return nil
+}
+
+// write the decoded frames at their offsets, as the decoder does
+func (b *bench) write() error {
+	b.eventsCh <- tui.NewEventStage("Write")
+	f, err := os.Create(filepath.Join(cfg.PathBenchDir, "out.bin"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	start := time.Now()
+	for i, data := range b.decoded {
+		_, err = f.WriteAt(data, int64(i)*int64(b.report.FrameSize))
+		if err != nil {
+			return err
+		}
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	wall := time.Since(start)
+	b.add(StageWrite, 0, wall, wall)
+	return nil
+}
+
+// parallel runs fn for every frame by the workers,
+// returns the wall time and the sum of the frame times
+func (b *bench) parallel(workers int, fn func(i int) error) (time.Duration, time.Duration, error) {
+	g, ctx := errgroup.WithContext(b.ctx)
+	next := make(chan int)
+	var mu sync.Mutex
+	var busy time.Duration
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		g.Go(func() error {
+			for i := range next {
+				t := time.Now()
+				err := fn(i)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				busy += time.Since(t)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	g.Go(func() error {
+		defer close(next)
+		for i := range b.chunks {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case next <- i:
+			}
+		}
+		return nil
+	})
+	err := g.Wait()
+	return time.Since(start), busy, err
+}
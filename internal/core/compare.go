@@ -3,19 +3,19 @@ package core
 import (
 	"fmt"
 	"os"
-
-	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"path/filepath"
 )
 
 // encode + decode + compare
-func (c *Core) Compare(filename string) (bool, error) {
-	defer os.Remove(cfg.PathVideoOut)
-
-	err := c.Encode(filename)
+func (c *Core) Compare(filename string, opts EncodeOptions) (bool, error) {
+	reel, err := c.Encode(filename, opts)
 	if err != nil {
 		return false, err
 	}
-	out, err := c.Decode(cfg.PathVideoOut)
+	for _, volume := range reel {
+		defer removeReel(volume)
+	}
+	out, err := c.Decode(reel, DecodeOptions{Backend: opts.Backend, Limits: opts.Limits})
 	if err != nil {
 		return false, err
 	}
@@ -33,6 +33,16 @@ func (c *Core) Compare(filename string) (bool, error) {
 	}
 }
 
+// removeReel removes the encoded video or the images,
+// the dir is removed only if nothing else is there
+func removeReel(path string) {
+	frames, _ := filepath.Glob(filepath.Join(path, "out_*.png"))
+	for _, frame := range frames {
+		os.Remove(frame)
+	}
+	os.Remove(path)
+}
+
 // Compare files before and after decoding for test command
 func compareFiles(file1, file2 string) (bool, error) {
 	// read files
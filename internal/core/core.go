@@ -4,14 +4,15 @@ import (
 	"context"
 
 	"github.com/1F47E/go-bitreel/internal/tui"
-	"github.com/1F47E/go-bitreel/internal/workers"
+	"github.com/1F47E/go-bitreel/internal/video"
 )
 
 type Core struct {
 	ctx      context.Context
 	logCh    chan string
 	eventsCh chan tui.Event
-	worker   *workers.Worker
+	// probed on the first run
+	ffmpeg *video.Capabilities
 }
 
 func NewCore(ctx context.Context, eventsCh chan tui.Event) *Core {
@@ -19,6 +20,5 @@ func NewCore(ctx context.Context, eventsCh chan tui.Event) *Core {
 		ctx:      ctx,
 		logCh:    make(chan string),
 		eventsCh: eventsCh,
-		worker:   workers.NewWorker(ctx),
 	}
 }
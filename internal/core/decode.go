@@ -1,219 +1,279 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"runtime"
-	"time"
+	"strings"
 
 	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/encoder"
 	"github.com/1F47E/go-bitreel/internal/job"
 	"github.com/1F47E/go-bitreel/internal/logger"
 	"github.com/1F47E/go-bitreel/internal/meta"
 	"github.com/1F47E/go-bitreel/internal/storage"
 	"github.com/1F47E/go-bitreel/internal/tui"
 	"github.com/1F47E/go-bitreel/internal/video"
+	"github.com/1F47E/go-bitreel/internal/workers"
+	"golang.org/x/sync/errgroup"
 )
 
-// 1. extract frames from video
-// 2. decode frames into bytes by workers, send results to separage channel in resChs
-// 3. write to result file continuously. Read from resChs in order from every worker
-func (c *Core) Decode(videoFile string) (string, error) {
-	log := logger.Log.WithField("scope", "core decode")
-	var err error
+type DecodeOptions struct {
+	// video is a camera or screen recording of a playing reel
+	Optical bool
+	// video backend: auto, ffmpeg or native
+	Backend string
+	// continue the interrupted decode from the journal in the work dir,
+	// or patch the damaged frames of the decoded file from another copy
+	Resume bool
+	// frames decoded ahead of the writer, bounds the memory, 0 - 2 per worker
+	Window int
+	Limits
+}
+
+// Decode restores the file from a reel or from all the volumes of a split reel, in any order.
+// Frames as images are decoded in any order, see decodeUnordered.
+// Video volumes are ordered by the headers and decoded one by one, see decodeVideo.
+// Frames are written at their offsets in the output, the journal keeps the written frames
+// so a failed decode is resumed with --resume.
+func (c *Core) Decode(inputs []string, opts DecodeOptions) (string, error) {
+	opts.apply()
+	inputs, err := expandVolumes(inputs)
+	if err != nil {
+		return "", err
+	}
+	out, err := openOutput(opts.Resume)
+	if err != nil {
+		return "", err
+	}
+	if !opts.Resume {
+		// frames of another decode
+		err = os.RemoveAll(cfg.PathFramesDir)
+		if err != nil {
+			return "", err
+		}
+	}
+	name, err := c.decodeInputs(inputs, opts, out)
+	if err != nil {
+		out.Close()
+		return "", err
+	}
+	return name, nil
+}
 
-	c.eventsCh <- tui.NewEventSpin("Decoding video...")
+func (c *Core) decodeInputs(inputs []string, opts DecodeOptions, out *sparseOutput) (string, error) {
+	source := strings.Join(inputs, ", ")
 
-	// extract frames from video
-	err = c.framesExtract(videoFile)
+	// frames as images, any order, frames of all the volumes are merged
+	frames, ok, err := c.readInputsImages(inputs)
+	if ok {
+		if err != nil {
+			return "", err
+		}
+		return c.decodeUnordered(frames, source, true, opts.workers(), out)
+	}
+
+	backend, err := c.newBackend(opts.Backend, inputs[0], opts.Limits, decodeEncoders, nil)
 	if err != nil {
 		return "", err
 	}
 
-	c.eventsCh <- tui.NewEventSpin("Scanning frames...")
+	if opts.Optical {
+		return c.decodeOptical(backend, inputs, opts, out)
+	}
+	if len(inputs) > 1 || opts.Resume {
+		// the first frame number of every volume is needed to skip the written frames
+		return c.decodeVolumes(backend, inputs, opts, out)
+	}
 
-	// scan dir for frames
-	filesList, err := storage.ScanFrames()
+	framesDir, err := storage.CreateFramesDir()
+	if err != nil {
+		return "", fmt.Errorf("Error creating frames dir: %w", err)
+	}
+	metadata, err := c.decodeVideo(backend, inputs[0], framesDir, 1, opts, out)
 	if err != nil {
 		return "", err
 	}
+	if metadata.Volumes() > 1 {
+		return "", fmt.Errorf("%s is the volume %d of %d, decode the volumes %s with --resume", inputs[0], metadata.Volume(), metadata.Volumes(),
+			missingVolumes(map[int]bool{metadata.Volume(): true}, metadata.Volumes()))
+	}
+	return c.saveDecoded(out, metadata)
+}
+
+// 1. extract frames from video, reuse the frames extracted before on resume
+// 2. decode frames into bytes by workers, the feeder waits for a slot in the reorder window,
+// frames written before on resume are skipped, first is the frame number of the first frame
+// 3. write to result file continuously, the window puts the results back in order
+func (c *Core) decodeVideo(backend video.Backend, videoFile, framesDir string, first int, opts DecodeOptions, out *sparseOutput) (meta.Metadata, error) {
+	log := logger.Log.WithField("scope", "core decode")
+
+	// extract frames from video
+	err := c.framesExtract(backend, videoFile, framesDir, opts.Resume)
+	if err != nil {
+		return meta.Metadata{}, err
+	}
+
+	// copy of the header and the frames index, if the reel has it in the audio track
+	c.eventsCh <- tui.NewEventStage("Reading audio")
+	manifest := c.readAudio(backend, videoFile)
+
+	c.eventsCh <- tui.NewEventStage("Decoding frames")
+
+	// scan dir for frames
+	filesList, err := storage.ListFrames(framesDir)
+	if err != nil {
+		return meta.Metadata{}, err
+	}
 	log.Debugf("total frames: %d", len(filesList))
 
-	// list of channels to receive results from workers in order
-	resChs := make([]chan job.JobDecRes, len(filesList))
-	for i := 0; i < len(filesList); i++ {
-		resChs[i] = make(chan job.JobDecRes, 1)
+	// block size is detected per frame by the decoder
+	enc, err := encoder.NewFrameEncoder(cfg.FrameWidth, cfg.FrameHeight, cfg.FrameBlock)
+	if err != nil {
+		return meta.Metadata{}, err
+	}
+	// written before, no need to decode
+	skip := make([]bool, len(filesList))
+	for i := range filesList {
+		skip[i] = out.Verified(first + i)
 	}
 
+	// an error of any worker or of the writer cancels the run
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
+	worker := workers.NewWorker(ctx, enc)
+	worker.SetManifest(manifest)
+
 	// create channels and start the workers
-	cores := runtime.NumCPU()
-	framesCh := make(chan job.JobDec, cores) // buff by G count
-	log.Debugf("Starting %d workers", cores)
-	for i := 0; i <= cores; i++ {
+	workersNum := opts.workers()
+	size := opts.Window
+	if size <= 0 {
+		size = 2 * workersNum
+	}
+	window := newReorderWindow(size)
+	framesCh := make(chan job.JobDec)
+	resCh := make(chan job.JobDecRes, size)
+	log.Debugf("Starting %d workers, window %d", workersNum, size)
+	for i := 0; i < workersNum; i++ {
 		i := i
-		go c.worker.WorkerDecode(i+1, framesCh, resChs)
+		g.Go(func() error {
+			return worker.WorkerDecode(i+1, framesCh, resCh)
+		})
 	}
 
-	// send all the jobs
-	go func() {
+	// send all the jobs, blocks while the window is full
+	g.Go(func() error {
+		defer close(framesCh)
 		for i, file := range filesList {
-			framesCh <- job.JobDec{File: file, Idx: i}
+			if !window.acquire(ctx) {
+				return nil
+			}
+			if skip[i] {
+				// the slot is freed by the writer as for a decoded frame
+				select {
+				case <-ctx.Done():
+					return nil
+				case resCh <- job.JobDecRes{Idx: i, Skipped: true}:
+				}
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case framesCh <- job.JobDec{File: file, Idx: i}:
+			}
 			log.Debugf("Sent file %d/%d", i+1, len(filesList))
 		}
-	}()
+		return nil
+	})
 
 	// Frames writer
 	// will start when all the frames are extracted
 	// Because its secuential and we need to write res file in order
-	out, err := c.framesWrite(resChs)
+	metadata, err := c.framesWrite(ctx, resCh, window, len(filesList), manifest, first, out)
 	if err != nil {
-		return "", err
-	}
-
-	// cleanup
-	log.Debug("Closing res channels")
-	for _, ch := range resChs {
-		close(ch)
+		cancel()
+		if werr := g.Wait(); werr != nil {
+			return meta.Metadata{}, werr
+		}
+		return meta.Metadata{}, err
 	}
-	log.Debug("Closing frames channel")
-	close(framesCh)
-
-	return out, nil
+	return metadata, g.Wait()
 }
 
-func (c *Core) framesExtract(videoFile string) error {
-	c.eventsCh <- tui.NewEventSpin("Decoding video...")
+func (c *Core) framesExtract(backend video.Backend, videoFile, framesDir string, reuse bool) error {
+	c.eventsCh <- tui.NewEventStage("Extracting frames")
 
-	// create dir to store frames
-	framesDir, err := storage.CreateFramesDir()
-	if err != nil {
-		return fmt.Errorf("Error creating frames dir: %w", err)
+	// frames extracted by the interrupted decode
+	if reuse && storage.FramesExtracted(framesDir, videoFile) {
+		return nil
 	}
 
-	// start frames progress reporter
-	c.eventsCh <- tui.NewEventSpin("Extracting frames...")
-	done := make(chan bool)
-
-	// fill scan frames folder untill video finishes extracting
-	// updates progress bar in a loop
-	go c.scanFramesDir(framesDir, videoFile, done)
+	// frames count from the container for the progress, 0 if unknown
+	total, err := backend.ProbeFrames(c.ctx, videoFile)
+	if err != nil {
+		logger.Log.Debugf("cannot probe frames count: %v", err)
+	}
 
-	err = video.ExtractFrames(c.ctx, videoFile, framesDir)
+	err = backend.ExtractFrames(c.ctx, videoFile, framesDir, c.videoProgress(total))
 	if err != nil {
 		return fmt.Errorf("Error extracting frames: %w", err)
 	}
-
-	close(done)
-	return nil
+	return storage.MarkExtracted(framesDir, videoFile)
 }
 
-func (c *Core) framesWrite(resChs []chan job.JobDecRes) (string, error) {
+// framesWrite writes the frames of the video in order, a frame with a broken header
+// is placed after the previous one. Reports the progress and the stats of every frame.
+func (c *Core) framesWrite(ctx context.Context, resCh <-chan job.JobDecRes, window *reorderWindow, total int, manifest *meta.Manifest, first int, out *sparseOutput) (meta.Metadata, error) {
 	log := logger.Log
-	var out string
-	var bytesWritten int
 	var metadata meta.Metadata
-	// Create a temporary file in the same directory
-	log.Debug("Reading res channels, writing to file")
-	tmpFile, err := storage.CreateTempFile()
-	if err != nil {
-		return "", fmt.Errorf("Cannot create temp file: %w", err)
-	}
-
-	c.eventsCh <- tui.NewEventSpin("Writing results...")
+	log.Debug("Reading results, writing to file")
 
-	// ranging over channels because work should be done in order
 	// write results to file, blocking, in order
-	for i, ch := range resChs {
-	loop:
-		for {
-			select {
-			case <-c.ctx.Done():
-				log.Debug("Decoder exit")
-				return out, c.ctx.Err()
-			case fr := <-ch:
-				log.Debugf("Waiting for the res from the worker #%d/%d", i+1, len(resChs))
-
+	frame := first - 1
+	var written int64
+	for !window.done(total) {
+		var res job.JobDecRes
+		select {
+		case <-ctx.Done():
+			log.Debug("Decoder exit")
+			return metadata, ctx.Err()
+		case res = <-resCh:
+		}
+		for _, fr := range window.put(res) {
+			log.Debugf("Writing the res #%d/%d - %d", fr.Idx+1, total, len(fr.Data))
+			frame++
+			if !fr.Skipped {
 				// set metadata if not set already
 				// it may be lost in some frames, check untill found
 				if fr.Meta.IsOk() && !metadata.IsOk() {
 					metadata = fr.Meta
 				}
-
-				log.Debugf("Got the res from the worker #%d/%d - %d", i+1, len(resChs), len(fr.Data))
-				written, err := tmpFile.Write(fr.Data)
+				if fr.Meta.IsOk() && fr.Meta.Frame() > 0 {
+					frame = fr.Meta.Frame()
+				}
+				err := out.WriteFrame(fr.Meta, frame, fr.Data, fr.FrameSize, fr.Valid)
 				if err != nil {
-					return "", fmt.Errorf("Cannot write to file: %w", err)
+					return metadata, err
+				}
+				written += int64(len(fr.Data))
+				damaged := 0
+				if !fr.Valid {
+					damaged = 1
+				}
+				if fr.Corrected > 0 || damaged > 0 {
+					c.eventsCh <- tui.NewEventStats(fr.Corrected, damaged)
 				}
-				bytesWritten += written
-				break loop
 			}
+			c.eventsCh <- tui.NewEventProgress(fr.Idx+1, total, written)
+			window.release()
 		}
 	}
 
-	// check metadata
-	statusMsg := ""
-	if metadata.IsOk() {
-		out = metadata.Filename
-		statusMsg = metadata.Print()
-	} else {
-		// default filename if no metadata found, unlikely to happen
-		out = "out_decoded.bin"
-		statusMsg = fmt.Sprintf("Metadata not found, result file - %s", out)
-	}
-	c.eventsCh <- tui.NewEventText(statusMsg)
-
-	err = storage.SaveDecoded(tmpFile, out)
-	if err != nil {
-		return "", fmt.Errorf("cannot save decoded file: %w", err)
-	}
-	return out, nil
-}
-
-// Decoding video to frames progress runner
-// NOTE: total frames count is unknown at this point
-// but the total size of all frames is about 3% less then a video (in a corrent compression case)
-// so we can use the video file size to estimate the total frames count
-func (c *Core) scanFramesDir(dir string, videoFile string, done <-chan bool) {
-	log := logger.Log
-	// get video file size
-	fileInfo, err := os.Stat(videoFile)
-	if err != nil {
-		log.Fatal("Error opening file:", err)
-	}
-	videoFileSize := fileInfo.Size()
-	totalFramesCount := int(videoFileSize/cfg.FrameFileSize - 1) // 3% error
-	log.Debug("Total frames count estimated:", totalFramesCount)
-
-	ticker := time.NewTicker(time.Second / 10)
-	defer ticker.Stop()
-
-	// update progress with estimated num of frames
-	c.eventsCh <- tui.NewEventBar(fmt.Sprintf("Extracting frames... %d/%d", 0, totalFramesCount), 0)
-
-	prevCount := 0
-	for {
-		select {
-		case <-c.ctx.Done():
-			return
-		case <-ticker.C:
-			// scan dir
-			files, err := os.ReadDir(dir)
-			if err != nil {
-				log.Warn("scanning dir error:", err)
-				break
-			}
-			// count files
-			l := len(files)
-			if l > prevCount {
-				prevCount = l
-
-				// update progress
-				percent := float64(l) / float64(totalFramesCount)
-				c.eventsCh <- tui.NewEventBar(fmt.Sprintf("Extracting frames... %d/%d", l, totalFramesCount), percent)
-			}
-			log.Debugf("Scanned %d/%d frames", l, totalFramesCount)
-		case <-done:
-			return
-		}
+	// all the frame headers are broken, the audio copy is the last resort
+	if !metadata.IsOk() && manifest != nil {
+		metadata = manifest.Meta
 	}
+	return metadata, nil
 }
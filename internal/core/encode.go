@@ -1,157 +1,423 @@
 package core
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"runtime"
-	"sync"
-	"time"
 
 	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/encoder"
 	"github.com/1F47E/go-bitreel/internal/job"
 	"github.com/1F47E/go-bitreel/internal/logger"
 	"github.com/1F47E/go-bitreel/internal/meta"
+	"github.com/1F47E/go-bitreel/internal/storage"
 	"github.com/1F47E/go-bitreel/internal/tui"
 	"github.com/1F47E/go-bitreel/internal/video"
+	"github.com/1F47E/go-bitreel/internal/workers"
+	"golang.org/x/sync/errgroup"
 )
 
+// Output formats
+const (
+	FormatVideo  = "video"
+	FormatImages = "images"
+	// printable pages, big blocks
+	FormatPDF = "pdf"
+	// animated GIF with a fixed palette, for platforms that accept only GIFs
+	FormatGIF = "gif"
+)
+
+type EncodeOptions struct {
+	// every bit is a Block x Block pixels square,
+	// bigger blocks survive downscaling by the video host.
+	// 0 - default for the format
+	Block int
+	// whiten the data so sparse files do not produce flat areas
+	Scramble bool
+	// interleaver depth, spreads neighbour bytes within the frame, 0 - off.
+	// There is no error correction, it only scatters the damaged bytes.
+	Interleave int
+	// keep a copy of the header and the frames index in the audio track
+	Audio bool
+	// write the reel summary to a .bitreel.json file next to the video
+	Sidecar bool
+	// video, images dir or zip path, default for the format if empty
+	Output string
+	// video, images or pdf
+	Format string
+	// video backend: auto, ffmpeg or native
+	Backend string
+	// split the reel into volumes of at most MaxFrames frames
+	// or MaxSize bytes of the output, 0 - no limit
+	MaxFrames int
+	MaxSize   int64
+	// continue the interrupted encode from the journal in the work dir
+	Resume bool
+	Limits
+}
+
 // 1. read file into buffer by chunks
 // 2. encode chunks to images and write to files as png frames
 // 3. encode frames into video
-func (c *Core) Encode(path string) error {
+// With the volume limits steps 2 and 3 run for every volume.
+// Returns the output paths, one per volume.
+func (c *Core) Encode(path string, opts EncodeOptions) ([]string, error) {
 	log := logger.Log
+	opts.apply()
+
+	width, height := cfg.FrameWidth, cfg.FrameHeight
+	if opts.Format == FormatPDF {
+		width, height = cfg.PageWidth, cfg.PageHeight
+		if opts.Block == 0 {
+			opts.Block = cfg.PageBlock
+		}
+	}
+	if opts.Block == 0 {
+		opts.Block = cfg.FrameBlock
+	}
+	enc, err := encoder.NewFrameEncoder(width, height, opts.Block)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Interleave < 0 || opts.Interleave > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid interleaver depth %d", opts.Interleave)
+	}
+	var backend video.Backend
+	switch opts.Format {
+	case FormatVideo, "":
+		encoders := append([]string{}, encodeEncoders...)
+		if opts.Audio {
+			encoders = append(encoders, audioEncoders...)
+		}
+		backend, err = c.newBackend(opts.Backend, "", opts.Limits, encoders, encodePixFmts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Output == "" {
+			opts.Output = defaultOutput(FormatVideo, backend.Name())
+		}
+	case FormatImages, FormatPDF, FormatGIF:
+		if opts.Audio {
+			return nil, fmt.Errorf("audio channel needs the video format")
+		}
+		if opts.Output == "" {
+			opts.Output = defaultOutput(opts.Format, "")
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q, use %s, %s, %s or %s", opts.Format, FormatVideo, FormatImages, FormatPDF, FormatGIF)
+	}
 
 	// open a file
 	file, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("error opening file: %w", err)
+		return nil, fmt.Errorf("error opening file: %w", err)
 	}
 	defer file.Close()
 
 	// Estimate amount of frames by the file size
 	// NOTE: read into buffer smaller then a frame to leave space for metadata
-	readBuffer := make([]byte, cfg.SizeFrame-cfg.SizeMetadata)
+	readBuffer := make([]byte, enc.Capacity()-cfg.SizeMetadata)
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("error getting file info: %w", err)
+		return nil, fmt.Errorf("error getting file info: %w", err)
 	}
 	size := fileInfo.Size()
-	estimatedFrames := int(int(size)/len(readBuffer)) + 1
+	estimatedFrames := (int(size) + len(readBuffer) - 1) / len(readBuffer)
 	log.Debug("Estimated frames:", estimatedFrames)
 
-	// ===== Encoding workers start
-
-	jobs := make(chan job.JobEnc)
-	numCpu := runtime.NumCPU()
+	// split into volumes, every volume is a reel of its own
+	var frameBytes int64
+	if opts.MaxSize > 0 {
+		c.eventsCh <- tui.NewEventSpin("Measuring the frame size...")
+		frameBytes, err = c.frameOutputSize(backend, enc, opts)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("Frame size in the output: %d bytes", frameBytes)
+	}
+	volumeFrames, volumes, err := splitVolumes(estimatedFrames, frameBytes, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	wg := sync.WaitGroup{}
-	for i := 0; i <= numCpu; i++ {
-		wg.Add(1)
-		i := i
-		go func() {
-			c.worker.WorkerEncode(i, jobs)
-			wg.Done()
-		}()
+	// continue the interrupted encode with the same settings or start over
+	input, err := newInputIdentity(path, file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	backendName := ""
+	if backend != nil {
+		backendName = backend.Name()
+	}
+	settings := newJournalSettings(opts, backendName)
+	journal := &encodeJournal{Input: input, Settings: settings}
+	if opts.Resume {
+		journal, err = readJournal()
+		if err != nil {
+			return nil, fmt.Errorf("nothing to resume: %w", err)
+		}
+		err = journal.check(input, settings)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resume: %w", err)
+		}
+	} else {
+		// frames of another encode
+		err = os.RemoveAll("tmp/out")
+		if err != nil {
+			return nil, fmt.Errorf("error removing tmp/out dir: %w", err)
+		}
 	}
 
 	// init metadata with filename and timestamp
 	md := meta.New(path)
+	if opts.Resume {
+		// frames already encoded have the header of the interrupted encode
+		md.SetTimestamp(journal.Timestamp)
+	}
+	journal.Timestamp = md.Timestamp()
+	md.SetTotal(estimatedFrames)
+	if opts.Scramble {
+		md.SetFlag(meta.FlagScrambled)
+	}
+	md.SetInterleave(opts.Interleave)
+
+	// digest of the whole file for the summary
+	digest := sha256.New()
+	sum, set := journal.SHA256, journal.Set
+	if volumes > 1 && !opts.Resume {
+		// the first volume is written before the file is read to the end
+		c.eventsCh <- tui.NewEventStage("Hashing file")
+		sum, err = hashFile(file)
+		if err != nil {
+			return nil, err
+		}
+		set, err = newSetID()
+		if err != nil {
+			return nil, err
+		}
+		journal.SHA256, journal.Set = sum, set
+	}
+	err = journal.save()
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []string
 	frameCnt := 1
+	for volume := 1; volume <= volumes; volume++ {
+		out := opts.Output
+		if volumes > 1 {
+			out = volumePath(opts.Output, volume)
+			md.SetVolume(set, volume, volumes, volumeFrames)
+		}
 
-	// job object will be updated with copy of the buffer and send to the channel
-	j := job.New(md, frameCnt)
-
-	// read file into the buffer by chunks
-loop:
-	for {
-		select {
-		case <-c.ctx.Done():
-			return c.ctx.Err()
-		default:
-			n, err := file.Read(readBuffer)
-			if err != nil {
-				if err == io.EOF {
-					log.Debug("EOF")
-					break loop
+		// volume completed before the interruption, skip its part of the file
+		if volume <= len(journal.Volumes) && journal.Volumes[volume-1] == out {
+			if _, err := os.Stat(out); err == nil {
+				_, err = file.Seek(int64(volumeFrames)*int64(len(readBuffer)), io.SeekCurrent)
+				if err != nil {
+					return nil, fmt.Errorf("error reading file: %w", err)
 				}
-				return fmt.Errorf("error reading file: %w", err)
+				c.eventsCh <- tui.NewEventText(fmt.Sprintf("Volume %d/%d is already encoded to %s", volume, volumes, out))
+				frameCnt += volumeFrames
+				outputs = append(outputs, out)
+				continue
 			}
-			// copy the buffer to the job
-			j.Update(readBuffer, n, frameCnt)
-			log.Debugf("Sending job for frame %d: %s\n", frameCnt, j.Print())
-			// this will block untill available worker pick it up
-			log.Debug(j.Print())
-			jobs <- j
-
-			// update progress bar with % of frames processed
-			percent := float64(frameCnt) / float64(estimatedFrames)
-			c.eventsCh <- tui.NewEventBar(fmt.Sprintf("Encoding %d/%d frames", frameCnt, estimatedFrames), percent)
-
-			frameCnt++
 		}
-	}
+		// the rest of the volumes are encoded again
+		journal.Volumes = outputs
+
+		// ===== Encoding workers start
 
-	// expected all the workers to finish and exit
-	close(jobs)
+		c.eventsCh <- tui.NewEventStage("Encoding frames")
 
-	// wait for all the files to be processed
-	wg.Wait()
-	log.Debug("All workers done")
+		// an error of any worker cancels the others and the reading
+		jobs := make(chan job.JobEnc)
+		g, ctx := errgroup.WithContext(c.ctx)
+		worker := workers.NewWorker(ctx, enc)
+		for i := 0; i < opts.workers(); i++ {
+			i := i
+			g.Go(func() error {
+				return worker.WorkerEncode(i, jobs)
+			})
+		}
 
-	// ====== Video encoding start
+		// frames index for the audio track
+		checksums := make([]uint64, 0, volumeFrames)
 
-	c.eventsCh <- tui.NewEventBar("Saving video... ", 0)
+		// job object will be updated with copy of the buffer and send to the channel
+		j := job.New(md, 1)
 
-	// check output size and report progress
-	done := make(chan bool)
-	go func() {
-		ticker := time.NewTicker(time.Second / 10)
-		defer ticker.Stop()
-		for {
+		// read the volume into the buffer by chunks, frame files are numbered from 1 in every volume
+		frames := 0
+	loop:
+		for frames < volumeFrames {
 			select {
-			case <-c.ctx.Done():
-				return
-			case <-done:
-				return
-			case <-ticker.C:
-
-				// get video file size
-				fileInfo, err := os.Stat(cfg.PathVideoOut)
+			case <-ctx.Done():
+				close(jobs)
+				return nil, workersError(c.ctx, g)
+			default:
+				n, err := file.Read(readBuffer)
 				if err != nil {
-					// no file yet
+					if err == io.EOF {
+						log.Debug("EOF")
+						break loop
+					}
+					close(jobs)
+					g.Wait()
+					return nil, fmt.Errorf("error reading file: %w", err)
+				}
+				frames++
+				// copy the buffer to the job
+				j.Update(readBuffer, n, frames)
+				if volumes <= 1 {
+					digest.Write(j.Buffer)
+				}
+				if opts.Audio {
+					checksums = append(checksums, meta.Sum(j.Buffer))
+				}
+				// frame encoded before the interruption
+				if opts.Resume && storage.FrameSaved(frames) {
+					frameCnt++
 					continue
 				}
-				videoFileSize := fileInfo.Size()
-				totalFramesCount := int(videoFileSize/cfg.FrameFileSize - 1) // 3% error
-				percent := float64(totalFramesCount) / (float64(estimatedFrames) * 1.03)
-				log.Debugf("Estimated frames written: %d/%d - %f%%", totalFramesCount, estimatedFrames, percent)
-				if percent > 1 {
-					percent = 1
+				log.Debugf("Sending job for frame %d: %s\n", frameCnt, j.Print())
+				// this will block untill available worker pick it up
+				log.Debug(j.Print())
+				select {
+				case jobs <- j:
+				case <-ctx.Done():
+					// workers are gone
+					close(jobs)
+					return nil, workersError(c.ctx, g)
+				}
+
+				// update progress with the frames and the file data processed
+				read := int64(frameCnt) * int64(len(readBuffer))
+				if read > size {
+					read = size
 				}
-				c.eventsCh <- tui.NewEventBar("Saving video... ", percent)
+				c.eventsCh <- tui.NewEventProgress(frameCnt, estimatedFrames, read)
+
+				frameCnt++
 			}
 		}
-	}()
 
-	// Call ffmpeg to encode frames into video
-	err = video.EncodeFrames(c.ctx)
+		// expected all the workers to finish and exit
+		close(jobs)
+
+		// wait for all the files to be processed
+		err = g.Wait()
+		if err != nil {
+			return nil, err
+		}
+		log.Debug("All workers done")
+
+		// ====== Video encoding start
+
+		if volumes <= 1 {
+			sum = hex.EncodeToString(digest.Sum(nil))
+		}
+		summary := meta.NewSummary(md)
+		summary.Size = size
+		summary.SHA256 = sum
+		summary.FrameSize = len(readBuffer)
+		summary.Width, summary.Height = width, height
+		summary.Settings.Block = opts.Block
+		summary.Settings.Audio = opts.Audio
+
+		err = c.writeVolume(backend, out, opts, summary, &meta.Manifest{Meta: md, Checksums: checksums}, frames)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, out)
+		journal.Volumes = outputs
+		err = journal.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Debug("\nVideo encoded")
+
+	// nothing to resume anymore
+	err = os.Remove(cfg.PathJournal)
 	if err != nil {
-		return fmt.Errorf("error encoding frames into video: %w", err)
+		log.Warnf("cannot remove journal: %v", err)
+	}
+
+	// update TUI
+	if len(outputs) > 1 {
+		c.eventsCh <- tui.NewEventText(fmt.Sprintf("Video encoded to %d volumes: %s ... %s", len(outputs), outputs[0], outputs[len(outputs)-1]))
+	} else {
+		c.eventsCh <- tui.NewEventText(fmt.Sprintf("Video encoded to %s", opts.Output))
+	}
+
+	return outputs, nil
+}
+
+// writeVolume saves the encoded frames in the output format and cleans them up
+func (c *Core) writeVolume(backend video.Backend, out string, opts EncodeOptions, summary *meta.Summary, manifest *meta.Manifest, frames int) error {
+	var err error
+	var audioFile string
+	if opts.Audio {
+		c.eventsCh <- tui.NewEventStage("Modulating audio")
+		audioFile, err = c.writeAudio(manifest)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(audioFile)
+	}
+
+	switch {
+	case opts.Format == FormatImages:
+		err = c.writeImages(out)
+		if err != nil {
+			return err
+		}
+	case opts.Format == FormatPDF:
+		err = c.writePDF(out, summary)
+		if err != nil {
+			return err
+		}
+	case opts.Format == FormatGIF:
+		err = c.writeGIF(out)
+		if err != nil {
+			return err
+		}
+	default:
+		// Call ffmpeg to encode frames into video
+		c.eventsCh <- tui.NewEventStage("Saving video")
+		err = backend.EncodeFrames(c.ctx, out, audioFile, summaryTags(summary), c.videoProgress(frames))
+		if err != nil {
+			return fmt.Errorf("error encoding frames into video: %w", err)
+		}
+	}
+
+	if opts.Sidecar {
+		err = writeSidecar(out, summary)
+		if err != nil {
+			return err
+		}
 	}
-	done <- true
-	close(done)
 
 	// clean up tmp/out dir
 	err = os.RemoveAll("tmp/out")
 	if err != nil {
 		return fmt.Errorf("error removing tmp/out dir: %w", err)
 	}
-	log.Debug("\nVideo encoded")
-
-	// update TUI
-	c.eventsCh <- tui.NewEventText("Video encoded!")
-	time.Sleep(1 * time.Second) // wait for TUI to update
-
 	return nil
 }
+
+// workersError waits for the workers and returns the error that stopped them,
+// or the cancellation of the run
+func workersError(ctx context.Context, g *errgroup.Group) error {
+	err := g.Wait()
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
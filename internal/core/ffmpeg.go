@@ -0,0 +1,42 @@
+package core
+
+import (
+	"github.com/1F47E/go-bitreel/internal/logger"
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// ffmpeg features the pipelines depend on, see video.FFmpeg
+var (
+	encodeEncoders = []string{"prores"}
+	encodePixFmts  = []string{"yuv422p10"}
+	audioEncoders  = []string{"pcm_s16le"}
+	decodeEncoders = []string{"png"}
+)
+
+// newBackend resolves the video backend and checks ffmpeg if it is picked,
+// filename is the video to read, empty on encoding
+func (c *Core) newBackend(name, filename string, limits Limits, encoders, pixFmts []string) (video.Backend, error) {
+	b, err := video.NewBackend(name, filename, limits.ffmpeg())
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.Debugf("video backend: %s", b.Name())
+	if b.Name() != video.BackendFFmpeg {
+		return b, nil
+	}
+	return b, c.requireFFmpeg(encoders, pixFmts)
+}
+
+// requireFFmpeg checks ffmpeg before the long run, not after the frames are done.
+// The probe is done once per core.
+func (c *Core) requireFFmpeg(encoders, pixFmts []string) error {
+	if c.ffmpeg == nil {
+		caps, err := video.Probe(c.ctx)
+		if err != nil {
+			return err
+		}
+		logger.Log.Debugf("ffmpeg %s at %s", caps.Version, caps.Path)
+		c.ffmpeg = caps
+	}
+	return c.ffmpeg.Require(encoders, pixFmts)
+}
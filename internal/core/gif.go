@@ -0,0 +1,65 @@
+package core
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+
+	"github.com/1F47E/go-bitreel/internal/gifstream"
+	"github.com/1F47E/go-bitreel/internal/storage"
+	"github.com/1F47E/go-bitreel/internal/tui"
+)
+
+// frame delay in 1/100 s, browsers slow down anything faster than 0.02 s
+const gifDelay = 10
+
+// writeGIF packs the encoded frames into an animated GIF with the fixed palette
+func (c *Core) writeGIF(out string) error {
+	frames, err := filepath.Glob("tmp/out/out_*.png")
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames to save")
+	}
+	w, err := gifstream.Create(out, gifDelay)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", out, err)
+	}
+	c.eventsCh <- tui.NewEventStage("Saving GIF")
+	for i, frame := range frames {
+		if c.ctx.Err() != nil {
+			w.Close()
+			return c.ctx.Err()
+		}
+		c.eventsCh <- tui.NewEventProgress(i+1, len(frames), 0)
+		img, err := storage.FrameRead(frame)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		err = w.WriteFrame(img)
+		if err != nil {
+			w.Close()
+			return fmt.Errorf("error writing frame %d: %w", i+1, err)
+		}
+	}
+	return w.Close()
+}
+
+// readGIF extracts the animation frames into the frames dir, ok is false if the input is not a GIF
+func readGIF(path, dir string) (frames []string, ok bool, err error) {
+	if !strings.EqualFold(filepath.Ext(path), ".gif") {
+		return nil, false, nil
+	}
+	err = gifstream.ReadFrames(path, func(img image.Image) error {
+		frame := filepath.Join(dir, fmt.Sprintf("out_%08d.png", len(frames)+1))
+		frames = append(frames, frame)
+		return storage.SaveImage(frame, img)
+	})
+	if err != nil {
+		return nil, true, err
+	}
+	return frames, true, nil
+}
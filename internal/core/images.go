@@ -0,0 +1,76 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/storage"
+	"github.com/1F47E/go-bitreel/internal/tui"
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// writeImages moves the encoded frames to a dir or packs them into a zip by the output extension
+func (c *Core) writeImages(out string) error {
+	c.eventsCh <- tui.NewEventStage("Saving images")
+	var n int
+	var err error
+	if isZip(out) {
+		n, err = storage.ZipFrames(out)
+	} else {
+		n, err = storage.MoveFrames(out)
+	}
+	if err != nil {
+		return fmt.Errorf("error saving images: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("no frames to save")
+	}
+	return nil
+}
+
+// readImages returns the frames if the input is a dir, a glob pattern, a zip of frames, a PDF or a GIF,
+// ok is false for a video file. Frames from archives are extracted to the dir.
+func readImages(path, dir string) (frames []string, ok bool, err error) {
+	frames, ok, err = readPDF(path, dir)
+	if ok {
+		return frames, ok, err
+	}
+	frames, ok, err = readGIF(path, dir)
+	if ok {
+		return frames, ok, err
+	}
+	fi, statErr := os.Stat(path)
+	switch {
+	case statErr == nil && fi.IsDir():
+	case statErr == nil && isZip(path):
+		frames, err = storage.UnzipFrames(path, dir)
+		return frames, true, err
+	case statErr != nil && strings.ContainsAny(path, "*?["):
+	default:
+		return nil, false, nil
+	}
+	frames, err = storage.ListFrames(path)
+	return frames, true, err
+}
+
+func isZip(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".zip")
+}
+
+// default output path for the format
+func defaultOutput(format, backend string) string {
+	switch {
+	case format == FormatImages:
+		return cfg.PathImagesOut
+	case format == FormatPDF:
+		return cfg.PathPDFOut
+	case format == FormatGIF:
+		return cfg.PathGIFOut
+	case backend == video.BackendNative:
+		return cfg.PathVideoOutNative
+	}
+	return cfg.PathVideoOut
+}
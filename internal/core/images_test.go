@@ -0,0 +1,47 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImagesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		// decode input, the output if empty
+		input string
+	}{
+		{"dir", "frames", ""},
+		{"glob", "frames", filepath.Join("frames", "*.png")},
+		{"zip", "frames.zip", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			c := newTestCore(t)
+			// 3 frames, the last one is partial
+			data, outs := encodeTestFile(t, c, 40000, EncodeOptions{Format: FormatImages, Output: tt.output})
+			if len(outs) != 1 || outs[0] != tt.output {
+				t.Fatalf("outputs %v, want %s", outs, tt.output)
+			}
+			input := tt.input
+			if input == "" {
+				input = tt.output
+			}
+			name, err := c.Decode([]string{input}, DecodeOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("decoded %d bytes differ from %d bytes encoded", len(got), len(data))
+			}
+		})
+	}
+}
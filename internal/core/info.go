@@ -0,0 +1,139 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/encoder"
+	"github.com/1F47E/go-bitreel/internal/logger"
+	"github.com/1F47E/go-bitreel/internal/meta"
+	"github.com/1F47E/go-bitreel/internal/storage"
+	"github.com/1F47E/go-bitreel/internal/tui"
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// container tag with the reel summary json
+const tagSummary = "comment"
+
+// sidecar manifest next to the video, out.mov -> out.bitreel.json
+func sidecarPath(videoFile string) string {
+	return strings.TrimSuffix(videoFile, filepath.Ext(videoFile)) + ".bitreel.json"
+}
+
+// summary tags for the container metadata
+func summaryTags(s *meta.Summary) map[string]string {
+	return map[string]string{
+		"title":    s.Filename,
+		tagSummary: s.JSON(),
+	}
+}
+
+func writeSidecar(videoFile string, s *meta.Summary) error {
+	err := os.WriteFile(sidecarPath(videoFile), []byte(s.JSON()+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("error writing sidecar: %w", err)
+	}
+	return nil
+}
+
+// Info reads the reel summary without decoding the whole video.
+// Sources in order: container tags, sidecar manifest, pixels of the first frame.
+// Returns the summary and the name of the source it was read from.
+func (c *Core) Info(videoFile string, opts DecodeOptions) (*meta.Summary, string, error) {
+	log := logger.Log.WithField("scope", "core info")
+
+	_, err := os.Stat(videoFile)
+	if err != nil {
+		return nil, "", err
+	}
+
+	backend, err := video.NewBackend(opts.Backend, videoFile, opts.ffmpeg())
+	if err != nil {
+		return nil, "", err
+	}
+
+	c.eventsCh <- tui.NewEventSpin("Reading container tags...")
+	err = video.Locate()
+	if err != nil {
+		log.Debugf("cannot locate ffmpeg: %v", err)
+	}
+	tags, err := backend.ProbeTags(c.ctx, videoFile)
+	if err != nil {
+		log.Debugf("cannot probe tags: %v", err)
+	} else if s, err := meta.ParseSummary([]byte(tags[tagSummary])); err == nil {
+		return s, "container tags", nil
+	}
+
+	data, err := os.ReadFile(sidecarPath(videoFile))
+	if err == nil {
+		s, err := meta.ParseSummary(data)
+		if err == nil {
+			return s, "sidecar", nil
+		}
+		log.Warnf("broken sidecar %s: %v", sidecarPath(videoFile), err)
+	}
+
+	// archives and the first frame are extracted to a dir of its own,
+	// the frames dir may hold the frames of an interrupted decode
+	dir, err := os.MkdirTemp("", "bitreel-info-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+
+	// frames as images, any of them has the header
+	frames, ok, err := readImages(videoFile, dir)
+	if ok {
+		if err != nil {
+			return nil, "", err
+		}
+		s, err := headerSummary(frames[0])
+		return s, "frame header", err
+	}
+
+	// decode the header from the first frame
+	_, err = c.newBackend(backend.Name(), videoFile, opts.Limits, decodeEncoders, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	c.eventsCh <- tui.NewEventSpin("Decoding first frame...")
+	frame := filepath.Join(dir, "info.png")
+	err = backend.ExtractFirstFrame(c.ctx, videoFile, frame)
+	if err != nil {
+		return nil, "", fmt.Errorf("error extracting first frame: %w", err)
+	}
+	s, err := headerSummary(frame)
+	return s, "first frame", err
+}
+
+// headerSummary decodes the header of the frame image, a video frame or a paper page
+func headerSummary(frame string) (*meta.Summary, error) {
+	enc, err := encoder.NewFrameEncoder(cfg.FrameWidth, cfg.FrameHeight, cfg.FrameBlock)
+	if err != nil {
+		return nil, err
+	}
+	page, err := encoder.NewFrameEncoder(cfg.PageWidth, cfg.PageHeight, cfg.PageBlock)
+	if err != nil {
+		return nil, err
+	}
+	img, err := storage.FrameRead(frame)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	enc = encoder.Closest([]*encoder.FrameEncoder{enc, page}, b.Dx(), b.Dy())
+	bytes, _, _, err := enc.DecodeCapture(frame)
+	if err != nil {
+		return nil, err
+	}
+	md, err := meta.Parse(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("no reel header found: %w", err)
+	}
+	s := meta.NewSummary(md)
+	s.Width, s.Height = enc.Size()
+	return s, nil
+}
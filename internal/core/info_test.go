@@ -0,0 +1,39 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+)
+
+func TestInfoKeepsFramesDir(t *testing.T) {
+	chdirTemp(t)
+	c := newTestCore(t)
+	_, outs := encodeTestFile(t, c, 1000, EncodeOptions{Format: FormatImages, Output: "frames"})
+
+	// frames of an interrupted decode, kept for --resume
+	marker := filepath.Join(cfg.PathFramesDir, "vol_001", ".extracted")
+	err := os.MkdirAll(filepath.Dir(marker), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(marker, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no tags and no sidecar, the header is read from the frame
+	s, source, err := c.Info(outs[0], DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "frame header" || s.Filename != "file.bin" || s.Frames != 1 {
+		t.Fatalf("summary %+v from %s", s, source)
+	}
+	_, err = os.Stat(marker)
+	if err != nil {
+		t.Fatalf("frames of the interrupted decode are gone: %v", err)
+	}
+}
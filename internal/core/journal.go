@@ -0,0 +1,140 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+)
+
+// encodeJournal is the state of the encode for --resume, written to the work dir before the frames.
+// Completed frames of the current volume are the frame files in tmp/out,
+// a frame file is renamed into place when it is complete.
+type encodeJournal struct {
+	Input    inputIdentity   `json:"input"`
+	Settings journalSettings `json:"settings"`
+	// header fields the frames were encoded with
+	Timestamp int64  `json:"timestamp"`
+	Set       uint64 `json:"set,omitempty"`
+	// sha256 of the whole file, hashed before the first volume
+	SHA256 string `json:"sha256,omitempty"`
+	// outputs of the completed volumes in order
+	Volumes []string `json:"volumes,omitempty"`
+}
+
+// inputIdentity tells if the input file is the same as in the interrupted encode,
+// hashing the whole file would take as long as reading it
+type inputIdentity struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // unix nano
+	// sha256 of the first and the last MB
+	Sample string `json:"sample"`
+}
+
+// journalSettings are the options that change the frames or the outputs
+type journalSettings struct {
+	Block      int    `json:"block"`
+	Scramble   bool   `json:"scramble"`
+	Interleave int    `json:"interleave"`
+	Audio      bool   `json:"audio"`
+	Format     string `json:"format"`
+	Output     string `json:"output"`
+	Backend    string `json:"backend,omitempty"`
+	MaxFrames  int    `json:"max_frames,omitempty"`
+	MaxSize    int64  `json:"max_size,omitempty"`
+}
+
+const identitySample = 1 << 20
+
+func newInputIdentity(path string, file *os.File) (inputIdentity, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return inputIdentity{}, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		return inputIdentity{}, err
+	}
+	digest := sha256.New()
+	_, err = io.Copy(digest, io.NewSectionReader(file, 0, identitySample))
+	if err != nil {
+		return inputIdentity{}, err
+	}
+	if tail := fi.Size() - identitySample; tail > identitySample {
+		_, err = io.Copy(digest, io.NewSectionReader(file, tail, identitySample))
+		if err != nil {
+			return inputIdentity{}, err
+		}
+	}
+	return inputIdentity{
+		Path:    abs,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UnixNano(),
+		Sample:  hex.EncodeToString(digest.Sum(nil)),
+	}, nil
+}
+
+func newJournalSettings(opts EncodeOptions, backend string) journalSettings {
+	return journalSettings{
+		Block:      opts.Block,
+		Scramble:   opts.Scramble,
+		Interleave: opts.Interleave,
+		Audio:      opts.Audio,
+		Format:     opts.Format,
+		Output:     opts.Output,
+		Backend:    backend,
+		MaxFrames:  opts.MaxFrames,
+		MaxSize:    opts.MaxSize,
+	}
+}
+
+func readJournal() (*encodeJournal, error) {
+	data, err := os.ReadFile(cfg.PathJournal)
+	if err != nil {
+		return nil, err
+	}
+	var j encodeJournal
+	err = json.Unmarshal(data, &j)
+	if err != nil {
+		return nil, fmt.Errorf("broken journal %s: %w", cfg.PathJournal, err)
+	}
+	return &j, nil
+}
+
+// check fails if the input or the settings differ from the interrupted encode
+func (j *encodeJournal) check(input inputIdentity, settings journalSettings) error {
+	if j.Input.Path != input.Path {
+		return fmt.Errorf("the interrupted encode was of %s", j.Input.Path)
+	}
+	if j.Input != input {
+		return fmt.Errorf("%s changed since the interrupted encode", input.Path)
+	}
+	if j.Settings != settings {
+		return fmt.Errorf("settings differ from the interrupted encode, it used %+v", j.Settings)
+	}
+	return nil
+}
+
+// save writes the journal to a temp file and renames it, so it is never half written
+func (j *encodeJournal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(cfg.PathJournal), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	tmp := cfg.PathJournal + ".part"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	return os.Rename(tmp, cfg.PathJournal)
+}
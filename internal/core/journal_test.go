@@ -0,0 +1,190 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalCheck(t *testing.T) {
+	input := inputIdentity{Path: "/data/file.bin", Size: 100, ModTime: 1, Sample: "ab"}
+	settings := journalSettings{Block: 2, Scramble: true, Format: FormatVideo, Output: "out.mov"}
+	j := &encodeJournal{Input: input, Settings: settings}
+
+	tests := []struct {
+		name   string
+		change func(i *inputIdentity, s *journalSettings)
+		err    string
+	}{
+		{"same", func(i *inputIdentity, s *journalSettings) {}, ""},
+		{"other file", func(i *inputIdentity, s *journalSettings) { i.Path = "/data/other.bin" }, "was of /data/file.bin"},
+		{"size", func(i *inputIdentity, s *journalSettings) { i.Size++ }, "changed"},
+		{"mtime", func(i *inputIdentity, s *journalSettings) { i.ModTime++ }, "changed"},
+		{"content", func(i *inputIdentity, s *journalSettings) { i.Sample = "cd" }, "changed"},
+		{"block", func(i *inputIdentity, s *journalSettings) { s.Block = 4 }, "settings differ"},
+		{"output", func(i *inputIdentity, s *journalSettings) { s.Output = "other.mov" }, "settings differ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, s := input, settings
+			tt.change(&i, &s)
+			err := j.check(i, s)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestInputIdentity(t *testing.T) {
+	chdirTemp(t)
+	identity := func(data []byte) inputIdentity {
+		t.Helper()
+		err := os.WriteFile("file.bin", data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// same mtime, only the content differs
+		err = os.Chtimes("file.bin", time.Unix(1, 0), time.Unix(1, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open("file.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		id, err := newInputIdentity("file.bin", f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	// head and tail samples and the middle which is not hashed
+	data := bytes.Repeat([]byte{1}, 3*identitySample)
+	id := identity(data)
+	if !filepath.IsAbs(id.Path) {
+		t.Fatalf("path %s is not absolute", id.Path)
+	}
+	for _, pos := range []int{0, len(data) - 1} {
+		changed := append([]byte(nil), data...)
+		changed[pos] = 2
+		if identity(changed) == id {
+			t.Fatalf("byte %d changed, identity is the same", pos)
+		}
+	}
+	changed := append([]byte(nil), data...)
+	changed[len(data)/2] = 2
+	if identity(changed) != id {
+		t.Fatal("identity of the same size and mtime differs, the middle is not sampled")
+	}
+}
+
+// TestEncodeResume interrupts an encode of 3 volumes on the second one and resumes it
+func TestEncodeResume(t *testing.T) {
+	chdirTemp(t)
+	c := newTestCore(t)
+	// 3 frames, a frame per volume
+	data := make([]byte, 40000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	err := os.MkdirAll("in", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join("in", "file.bin")
+	err = os.WriteFile(input, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := EncodeOptions{Format: FormatImages, Output: "frames.zip", MaxFrames: 1, Block: 8}
+	opts.Jobs = 2
+	resume := opts
+	resume.Resume = true
+	_, err = c.Encode(input, resume)
+	if err == nil || !strings.Contains(err.Error(), "nothing to resume") {
+		t.Fatalf("error %v, want nothing to resume", err)
+	}
+
+	// the second volume cannot be written over a dir
+	err = os.MkdirAll("frames.002.zip", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Encode(input, opts)
+	if err == nil {
+		t.Fatal("encode over a dir did not fail")
+	}
+	err = os.Remove("frames.002.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first volume is kept, it is not written again
+	old := time.Unix(1, 0)
+	err = os.Chtimes("frames.001.zip", old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := resume
+	other.Interleave = 4
+	_, err = c.Encode(input, other)
+	if err == nil || !strings.Contains(err.Error(), "settings differ") {
+		t.Fatalf("error %v, want settings differ", err)
+	}
+
+	fi, err := os.Stat(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(input, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Encode(input, resume)
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Fatalf("error %v, want the input changed", err)
+	}
+	err = os.Chtimes(input, fi.ModTime(), fi.ModTime())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outs, err := c.Encode(input, resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"frames.001.zip", "frames.002.zip", "frames.003.zip"}
+	if strings.Join(outs, " ") != strings.Join(want, " ") {
+		t.Fatalf("outputs %v, want %v", outs, want)
+	}
+	fi, err = os.Stat("frames.001.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(old) {
+		t.Fatal("the completed volume is encoded again")
+	}
+
+	name, err := c.Decode(outs, DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("decoded data differs")
+	}
+}
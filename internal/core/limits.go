@@ -0,0 +1,62 @@
+package core
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/1F47E/go-bitreel/internal/logger"
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// soft limit of the Go heap in the nice mode, the frames of the workers fit well under it.
+// Only bitreel itself is limited, ffmpeg runs as a separate process with its own memory.
+const niceHeapLimit = 1 << 30
+
+// Limits of the machine resources a run takes, for shared servers
+type Limits struct {
+	// frame workers, 0 - one per CPU, half of the CPUs in the nice mode
+	Jobs int
+	// threads per ffmpeg run, 0 - ffmpeg decides, as many as the workers in the nice mode
+	FFmpegThreads int
+	// lower the CPU and IO priority of bitreel and ffmpeg, limit the Go heap of bitreel
+	Nice bool
+}
+
+func (l Limits) workers() int {
+	if l.Jobs > 0 {
+		return l.Jobs
+	}
+	n := runtime.NumCPU()
+	if l.Nice {
+		n = (n + 1) / 2
+	}
+	return n
+}
+
+func (l Limits) ffmpegThreads() int {
+	if l.FFmpegThreads > 0 || !l.Nice {
+		return l.FFmpegThreads
+	}
+	return l.workers()
+}
+
+// ffmpeg settings of the video backend, ffmpeg is started with the lower priority in the nice mode
+func (l Limits) ffmpeg() video.FFmpeg {
+	return video.FFmpeg{Threads: l.ffmpegThreads(), Nice: l.Nice}
+}
+
+// apply the nice mode to every thread of the process.
+// The priority is not restored, it is lowered for the rest of the process.
+func (l Limits) apply() {
+	if !l.Nice {
+		return
+	}
+	err := lowerPriority()
+	if err != nil {
+		logger.Log.Warnf("cannot lower the priority: %v", err)
+	}
+	// the GC works harder near the limit, ffmpeg memory is not counted
+	if debug.SetMemoryLimit(-1) > niceHeapLimit {
+		debug.SetMemoryLimit(niceHeapLimit)
+	}
+}
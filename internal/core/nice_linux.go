@@ -0,0 +1,77 @@
+package core
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// IO priority: best effort class, lowest level
+const (
+	ioprioWhoProcess = 1
+	ioprioClassBE    = 2
+	ioprioClassShift = 13
+	ioprioLevel      = 7
+)
+
+// lowerPriority lowers the CPU and IO priority of every thread.
+// On linux both are set per thread, new threads take them from the thread that starts them,
+// so the threads are listed again until no new ones show up.
+func lowerPriority() error {
+	done := make(map[int]bool)
+	for {
+		tids, err := threads()
+		if err != nil {
+			return err
+		}
+		lowered := 0
+		for _, tid := range tids {
+			if done[tid] {
+				continue
+			}
+			err = lowerThreadPriority(tid)
+			// the thread is gone
+			if err == syscall.ESRCH {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			done[tid] = true
+			lowered++
+		}
+		if lowered == 0 {
+			return nil
+		}
+	}
+}
+
+func lowerThreadPriority(tid int) error {
+	err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, video.NiceLevel)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassBE<<ioprioClassShift|ioprioLevel)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// threads returns the thread ids of the process
+func threads() ([]int, error) {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil, err
+	}
+	tids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		tid, err := strconv.Atoi(e.Name())
+		if err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
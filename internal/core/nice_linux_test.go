@@ -0,0 +1,75 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// the test lowers the priority of the process for good, it runs in a child process
+const envNiceChild = "BITREEL_TEST_NICE_CHILD"
+
+func TestLowerPriorityAllThreads(t *testing.T) {
+	if os.Getenv(envNiceChild) == "" {
+		// raw getpriority returns 20 - nice, raising the priority back needs privileges
+		prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nice := 20 - prio; nice > video.NiceLevel {
+			t.Skipf("nice %d is above %d already", nice, video.NiceLevel)
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=^TestLowerPriorityAllThreads$", "-test.v")
+		cmd.Env = append(os.Environ(), envNiceChild+"=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil || !strings.Contains(string(out), "--- PASS") {
+			t.Fatalf("child test: %v\n%s", err, out)
+		}
+		return
+	}
+
+	// threads of the workers, locked so they are not shared
+	var started, stop sync.WaitGroup
+	stop.Add(1)
+	for i := 0; i < 4; i++ {
+		started.Add(1)
+		go func() {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			started.Done()
+			stop.Wait()
+		}()
+	}
+	started.Wait()
+	defer stop.Done()
+
+	err := lowerPriority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tids, err := threads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tids) < 5 {
+		t.Fatalf("%d threads, want the workers too", len(tids))
+	}
+	for _, tid := range tids {
+		stat, err := os.ReadFile("/proc/self/task/" + strconv.Itoa(tid) + "/stat")
+		if err != nil {
+			continue
+		}
+		// nice is the 19th field, the fields after the command name in parentheses start from the 3rd
+		fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+		if fields[16] != strconv.Itoa(video.NiceLevel) {
+			t.Fatalf("thread %d nice %s, want %d", tid, fields[16], video.NiceLevel)
+		}
+	}
+}
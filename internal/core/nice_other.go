@@ -0,0 +1,9 @@
+//go:build !unix
+
+package core
+
+import "fmt"
+
+func lowerPriority() error {
+	return fmt.Errorf("not supported on this platform")
+}
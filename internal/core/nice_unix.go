@@ -0,0 +1,14 @@
+//go:build unix && !linux
+
+package core
+
+import (
+	"syscall"
+
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// the priority is set for the whole process outside of linux, no IO priority
+func lowerPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Getpid(), video.NiceLevel)
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/encoder"
+	"github.com/1F47E/go-bitreel/internal/job"
+	"github.com/1F47E/go-bitreel/internal/logger"
+	"github.com/1F47E/go-bitreel/internal/meta"
+	"github.com/1F47E/go-bitreel/internal/storage"
+	"github.com/1F47E/go-bitreel/internal/tui"
+	"github.com/1F47E/go-bitreel/internal/video"
+	"github.com/1F47E/go-bitreel/internal/workers"
+	"golang.org/x/sync/errgroup"
+)
+
+// Optical decoding of a reel recorded with a camera or a screen capture.
+// Recording fps does not match the reel so every frame is captured
+// zero or more times, some captures are blurred or show two frames at once.
+// 1. extract all the captures from the recordings, one per volume
+// 2. decode captures by frame sequence number, see decodeUnordered
+func (c *Core) decodeOptical(backend video.Backend, videoFiles []string, opts DecodeOptions, out *sparseOutput) (string, error) {
+	var filesList []string
+	for i, videoFile := range videoFiles {
+		dir, err := storage.CreateVolumeDir(i + 1)
+		if err != nil {
+			return "", err
+		}
+		err = c.framesExtract(backend, videoFile, dir, opts.Resume)
+		if err != nil {
+			return "", err
+		}
+
+		c.eventsCh <- tui.NewEventStage("Scanning captures")
+		files, err := storage.ListFrames(dir)
+		if err != nil {
+			return "", err
+		}
+		filesList = append(filesList, files...)
+	}
+	return c.decodeUnordered(filesList, strings.Join(videoFiles, ", "), false, opts.workers(), out)
+}
+
+// decodeUnordered decodes frames in any order, the same frame can be there many times.
+// 1. decode frames by workers, any order
+// 2. write a frame with a valid checksum at its offset as it comes, a verified frame is not overwritten
+// 3. keep the sharpest capture of the frames with no valid one yet, write them as damaged at the end
+// 4. fail if some frames are missing and not written before
+// Images can also be paper page scans, video recordings can not.
+func (c *Core) decodeUnordered(filesList []string, source string, paper bool, workersNum int, out *sparseOutput) (string, error) {
+	log := logger.Log.WithField("scope", "core unordered")
+	log.Debugf("total files: %d", len(filesList))
+
+	// block size and perspective are detected per capture
+	enc, err := encoder.NewFrameEncoder(cfg.FrameWidth, cfg.FrameHeight, cfg.FrameBlock)
+	if err != nil {
+		return "", err
+	}
+	// an error of any worker or of the writer cancels the run
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
+	worker := workers.NewWorker(ctx, enc)
+	if paper {
+		page, err := encoder.NewFrameEncoder(cfg.PageWidth, cfg.PageHeight, cfg.PageBlock)
+		if err != nil {
+			return "", err
+		}
+		worker.AddGeometry(page)
+	}
+
+	framesCh := make(chan job.JobDec, workersNum)
+	resCh := make(chan job.JobDecRes, workersNum)
+	for i := 0; i < workersNum; i++ {
+		i := i
+		g.Go(func() error {
+			return worker.WorkerCapture(i+1, framesCh, resCh)
+		})
+	}
+	g.Go(func() error {
+		defer close(framesCh)
+		for i, file := range filesList {
+			select {
+			case <-ctx.Done():
+				return nil
+			case framesCh <- job.JobDec{File: file, Idx: i}:
+			}
+		}
+		return nil
+	})
+
+	c.eventsCh <- tui.NewEventStage("Decoding frames")
+	// only the damaged captures are kept, the memory does not grow with the frames count
+	damaged := make(map[int]job.JobDecRes)
+	var metadata meta.Metadata
+	var last int // total frames, known from the first valid capture
+	for i := range filesList {
+		var res job.JobDecRes
+		select {
+		case <-ctx.Done():
+			return "", workersError(c.ctx, g)
+		case res = <-resCh:
+		}
+		c.eventsCh <- tui.NewEventProgress(i+1, len(filesList), 0)
+
+		n := res.Meta.Frame()
+		if n == 0 || out.Verified(n) {
+			continue
+		}
+		if !res.Valid {
+			if prev, ok := damaged[n]; !ok || isBetterCapture(res, prev) {
+				damaged[n] = res
+			}
+			continue
+		}
+		if !metadata.IsOk() {
+			metadata = res.Meta
+			last = metadata.Total()
+		}
+		err := out.WriteFrame(res.Meta, n, res.Data, res.FrameSize, true)
+		if err != nil {
+			cancel()
+			if werr := g.Wait(); werr != nil {
+				return "", werr
+			}
+			return "", err
+		}
+		delete(damaged, n)
+	}
+	err = g.Wait()
+	if err != nil {
+		return "", err
+	}
+
+	if last == 0 {
+		return "", fmt.Errorf("no valid frames found in %s", source)
+	}
+
+	// found frames are written even if some are missing, the rest can be decoded later with --resume
+	frames := make([]int, 0, len(damaged))
+	for n := range damaged {
+		if n <= last {
+			frames = append(frames, n)
+		}
+	}
+	if len(frames) > 0 {
+		c.eventsCh <- tui.NewEventStage("Writing damaged frames")
+	}
+	sort.Ints(frames)
+	for _, n := range frames {
+		res := damaged[n]
+		log.Warnf("frame %d checksum mismatch in all captures, best sharpness %.2f", n, res.Sharpness)
+		c.eventsCh <- tui.NewEventStats(0, 1)
+		err := out.WriteFrame(res.Meta, n, res.Data, res.FrameSize, false)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// frames are numbered from 1, every number up to the total should be captured
+	if missing := out.Missing(); len(missing) > 0 {
+		if volumes := missingFramesVolumes(metadata, out); volumes != "" {
+			return "", fmt.Errorf("missing volumes of %s: %s of %d, decode them with --resume", metadata.Filename, volumes, metadata.Volumes())
+		}
+		return "", fmt.Errorf("frames not found: %s, decode them with --resume", formatFrames(missing))
+	}
+	return c.saveDecoded(out, metadata)
+}
+
+// valid checksum wins, then the sharper capture
+func isBetterCapture(a, b job.JobDecRes) bool {
+	if a.Valid != b.Valid {
+		return a.Valid
+	}
+	return a.Sharpness > b.Sharpness
+}
+
+// missingFramesVolumes lists the volumes with no frames in the output, empty for a single reel
+func missingFramesVolumes(metadata meta.Metadata, out *sparseOutput) string {
+	if metadata.Volumes() <= 1 {
+		return ""
+	}
+	have := make(map[int]bool)
+	for n := 1; n <= metadata.Total(); n++ {
+		if out.Written(n) {
+			have[metadata.VolumeOf(n)] = true
+		}
+	}
+	return missingVolumes(have, metadata.Volumes())
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/job"
+	"github.com/1F47E/go-bitreel/internal/storage"
+	"github.com/1F47E/go-bitreel/internal/tui"
+)
+
+func TestIsBetterCapture(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b job.JobDecRes
+		want bool
+	}{
+		{"sharper", job.JobDecRes{Valid: true, Sharpness: 0.9}, job.JobDecRes{Valid: true, Sharpness: 0.5}, true},
+		{"blurrier", job.JobDecRes{Valid: true, Sharpness: 0.5}, job.JobDecRes{Valid: true, Sharpness: 0.9}, false},
+		{"same, the first capture stays", job.JobDecRes{Valid: true, Sharpness: 1}, job.JobDecRes{Valid: true, Sharpness: 1}, false},
+		{"valid over sharper", job.JobDecRes{Valid: true, Sharpness: 0.3}, job.JobDecRes{Sharpness: 1}, true},
+		{"sharper but broken", job.JobDecRes{Sharpness: 1}, job.JobDecRes{Valid: true, Sharpness: 0.3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBetterCapture(tt.a, tt.b); got != tt.want {
+				t.Fatalf("isBetterCapture = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestCore drops the events of the run
+func newTestCore(t *testing.T) *Core {
+	t.Helper()
+	eventsCh := make(chan tui.Event)
+	go func() {
+		for range eventsCh {
+		}
+	}()
+	t.Cleanup(func() { close(eventsCh) })
+	return NewCore(context.Background(), eventsCh)
+}
+
+// encodeTestFile encodes random data as a reel of 8px blocks, a frame holds about 16KB.
+// Returns the data, the input is in a dir of its own so the decoded file does not replace it.
+func encodeTestFile(t *testing.T, c *Core, size int, opts EncodeOptions) ([]byte, []string) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	err := os.MkdirAll("in", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join("in", "file.bin"), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Block = 8
+	opts.Jobs = 2
+	outs, err := c.Encode(filepath.Join("in", "file.bin"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, outs
+}
+
+// damage paints a black square in the middle of the frame, the header at the top stays readable
+func damage(t *testing.T, src, dst string) {
+	t.Helper()
+	img, err := storage.FrameRead(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("%s is not a gray image", src)
+	}
+	b := gray.Bounds()
+	for y := b.Dy() / 2; y < b.Dy()/2+200; y++ {
+		for x := b.Dx() / 2; x < b.Dx()/2+200; x++ {
+			gray.SetGray(x, y, color.Gray{})
+		}
+	}
+	err = storage.SaveImage(dst, gray)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeUnorderedDamaged(t *testing.T) {
+	chdirTemp(t)
+	c := newTestCore(t)
+	data, outs := encodeTestFile(t, c, 40000, EncodeOptions{Format: FormatImages, Output: "frames", Scramble: true})
+	frames, err := storage.ListFrames(outs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("%d frames, want 3", len(frames))
+	}
+	// damaged captures of the frame 2 around the good one, the frame 3 is only damaged
+	damage(t, frames[1], filepath.Join(outs[0], "a_damaged.png"))
+	damage(t, frames[1], filepath.Join(outs[0], "z_damaged.png"))
+	damage(t, frames[2], frames[2])
+
+	name, err := c.Decode([]string{outs[0]}, DecodeOptions{Limits: Limits{Jobs: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(data) {
+		t.Fatalf("decoded %d bytes, want %d", len(got), len(data))
+	}
+	journal, err := os.ReadFile(cfg.PathDecodeJournal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j decodeJournal
+	err = json.Unmarshal(journal, &j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(j.Verified.list(), []int{1, 2}) || !reflect.DeepEqual(j.Damaged.list(), []int{3}) {
+		t.Fatalf("verified %v, damaged %v, want [1 2] and [3]", j.Verified.list(), j.Damaged.list())
+	}
+	// the good capture of the frame 2 is not overwritten by the damaged ones
+	if !bytes.Equal(got[:2*j.FrameSize], data[:2*j.FrameSize]) {
+		t.Fatal("verified frames differ")
+	}
+}
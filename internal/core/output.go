@@ -0,0 +1,319 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/meta"
+	"github.com/1F47E/go-bitreel/internal/storage"
+	"github.com/1F47E/go-bitreel/internal/tui"
+)
+
+// decodeJournal tracks the frames written to the output of an interrupted or damaged decode.
+// Frames with a valid checksum are verified, the rest are damaged
+// and can be patched from another copy of the reel with --resume.
+type decodeJournal struct {
+	// identity of the reel
+	Filename  string `json:"filename"`
+	Timestamp int64  `json:"timestamp"`
+	Set       uint64 `json:"set,omitempty"`
+	Total     int    `json:"total"`
+	// data bytes of every frame but the last one, offset of the frame is (frame - 1) * FrameSize
+	FrameSize int `json:"frame_size"`
+	// size of the original file, known when the last frame is written with a readable header
+	Size int64 `json:"size,omitempty"`
+	// the part file while decoding, the saved file if it has damaged frames
+	Output   string   `json:"output"`
+	Verified frameSet `json:"verified"`
+	Damaged  frameSet `json:"damaged,omitempty"`
+}
+
+// how often the journal is written while decoding
+const journalInterval = time.Second
+
+// sparseOutput writes the frames at the offsets by the sequence numbers,
+// so frames can come in any order, from many volumes or many decode runs
+type sparseOutput struct {
+	f       *os.File
+	journal *decodeJournal
+	saved   time.Time
+}
+
+// openOutput starts a new output or, on resume, continues the one from the journal
+func openOutput(resume bool) (*sparseOutput, error) {
+	if !resume {
+		err := os.Remove(cfg.PathDecodeJournal)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return &sparseOutput{journal: &decodeJournal{}}, nil
+	}
+	data, err := os.ReadFile(cfg.PathDecodeJournal)
+	if err != nil {
+		return nil, fmt.Errorf("nothing to resume: %w", err)
+	}
+	var j decodeJournal
+	err = json.Unmarshal(data, &j)
+	if err != nil {
+		return nil, fmt.Errorf("broken journal %s: %w", cfg.PathDecodeJournal, err)
+	}
+	f, err := os.OpenFile(j.Output, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resume: %w", err)
+	}
+	return &sparseOutput{f: f, journal: &j}, nil
+}
+
+// Verified reports if the frame is in the output already with a valid checksum
+func (o *sparseOutput) Verified(frame int) bool {
+	return o.journal.Verified[frame]
+}
+
+// WriteFrame writes the frame data at its offset. A damaged frame does not overwrite a verified one.
+// frameSize is the data bytes of a full frame, the frame capacity without the header.
+func (o *sparseOutput) WriteFrame(md meta.Metadata, frame int, data []byte, frameSize int, valid bool) error {
+	if o.f == nil {
+		err := o.create(md)
+		if err != nil {
+			return err
+		}
+	}
+	j := o.journal
+	if md.IsOk() && j.Filename == "" {
+		// the first frames had broken headers
+		j.Filename, j.Timestamp, j.Set, j.Total = md.Filename, md.Timestamp(), md.Set(), md.Total()
+	} else if md.IsOk() && (md.Filename != j.Filename || md.Timestamp() != j.Timestamp || md.Set() != j.Set) {
+		return fmt.Errorf("frame %d is from another reel %s, the output is of %s", frame, md.Filename, j.Filename)
+	}
+	if j.Verified[frame] {
+		return nil
+	}
+
+	// every frame but the last one is full, the last one may be the first to come
+	if j.FrameSize == 0 {
+		j.FrameSize = frameSize
+	}
+	// a frame with a broken header has no data size, it must not run into the next frame
+	if len(data) > j.FrameSize && j.FrameSize > 0 {
+		data = data[:j.FrameSize]
+	}
+	offset := int64(frame-1) * int64(j.FrameSize)
+	_, err := o.f.WriteAt(data, offset)
+	if err != nil {
+		return fmt.Errorf("Cannot write to file: %w", err)
+	}
+	// a broken header has no data size, the frame is all the cells
+	if frame == j.Total && md.IsOk() {
+		j.Size = offset + int64(len(data))
+	}
+	if valid {
+		j.Verified.add(frame)
+		delete(j.Damaged, frame)
+	} else {
+		j.Damaged.add(frame)
+	}
+
+	if time.Since(o.saved) > journalInterval {
+		return o.save()
+	}
+	return nil
+}
+
+// create starts the part file for the reel of the first frame
+func (o *sparseOutput) create(md meta.Metadata) error {
+	f, err := os.Create(cfg.PathDecodePart)
+	if err != nil {
+		return fmt.Errorf("Cannot create temp file: %w", err)
+	}
+	o.f = f
+	o.journal = &decodeJournal{
+		Filename:  md.Filename,
+		Timestamp: md.Timestamp(),
+		Set:       md.Set(),
+		Total:     md.Total(),
+		Output:    cfg.PathDecodePart,
+		Verified:  make(frameSet),
+		Damaged:   make(frameSet),
+	}
+	return o.save()
+}
+
+// Written reports if the frame is in the output, verified or damaged
+func (o *sparseOutput) Written(frame int) bool {
+	return o.journal.Verified[frame] || o.journal.Damaged[frame]
+}
+
+// Missing returns the frames not written from 1 to the total
+func (o *sparseOutput) Missing() []int {
+	var missing []int
+	for n := 1; n <= o.journal.Total; n++ {
+		if !o.Written(n) {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
+func (o *sparseOutput) save() error {
+	o.saved = time.Now()
+	data, err := json.Marshal(o.journal)
+	if err != nil {
+		return err
+	}
+	tmp := cfg.PathDecodeJournal + ".part"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	return os.Rename(tmp, cfg.PathDecodeJournal)
+}
+
+// Close keeps the journal to resume the decode later
+func (o *sparseOutput) Close() error {
+	if o.f == nil {
+		return nil
+	}
+	err := o.save()
+	if err != nil {
+		o.f.Close()
+		return err
+	}
+	return o.f.Close()
+}
+
+// saveDecoded checks all the frames are there and renames the output to the original filename from metadata.
+// The journal is kept if some frames are damaged, decoding another copy with --resume patches them.
+func (c *Core) saveDecoded(o *sparseOutput, metadata meta.Metadata) (string, error) {
+	j := o.journal
+	if o.f == nil {
+		return "", fmt.Errorf("no frames decoded")
+	}
+	if missing := o.Missing(); len(missing) > 0 {
+		return "", fmt.Errorf("frames not found: %s, decode them with --resume", formatFrames(missing))
+	}
+	if j.Size > 0 {
+		// a longer output of an earlier run
+		err := o.f.Truncate(j.Size)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var out string
+	// check metadata
+	statusMsg := ""
+	if metadata.IsOk() {
+		out = metadata.Filename
+		statusMsg = metadata.Print()
+	} else if j.Filename != "" {
+		// all the frames were written before the resume
+		out = j.Filename
+		statusMsg = fmt.Sprintf("Filename: %s", out)
+	} else {
+		// default filename if no metadata found, unlikely to happen
+		out = "out_decoded.bin"
+		statusMsg = fmt.Sprintf("Metadata not found, result file - %s", out)
+	}
+	if j.Output != cfg.PathDecodePart {
+		// patched the file saved before
+		out = j.Output
+	}
+	c.eventsCh <- tui.NewEventStage("Saving file")
+	c.eventsCh <- tui.NewEventText(statusMsg)
+
+	err := storage.SaveDecoded(o.f, out)
+	if err != nil {
+		return "", fmt.Errorf("cannot save decoded file: %w", err)
+	}
+	if len(j.Damaged) > 0 {
+		j.Output = out
+		err = o.save()
+		if err != nil {
+			return "", err
+		}
+		c.eventsCh <- tui.NewEventText(fmt.Sprintf("Damaged frames: %s, decode another copy with --resume to patch %s", formatFrames(j.Damaged.list()), out))
+		return out, nil
+	}
+	err = os.Remove(cfg.PathDecodeJournal)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return out, nil
+}
+
+// frameSet is a set of frame numbers, stored as ranges: "1-500,502"
+type frameSet map[int]bool
+
+func (s *frameSet) add(frame int) {
+	if *s == nil {
+		*s = make(frameSet)
+	}
+	(*s)[frame] = true
+}
+
+func (s frameSet) list() []int {
+	frames := make([]int, 0, len(s))
+	for n := range s {
+		frames = append(frames, n)
+	}
+	sort.Ints(frames)
+	return frames
+}
+
+func (s frameSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(formatFrames(s.list()))
+}
+
+func (s *frameSet) UnmarshalJSON(data []byte) error {
+	var text string
+	err := json.Unmarshal(data, &text)
+	if err != nil {
+		return err
+	}
+	*s = make(frameSet)
+	for _, r := range strings.Split(text, ",") {
+		if r == "" {
+			continue
+		}
+		from, to, found := strings.Cut(r, "-")
+		a, err := strconv.Atoi(from)
+		if err != nil {
+			return fmt.Errorf("bad frames range %q", r)
+		}
+		b := a
+		if found {
+			b, err = strconv.Atoi(to)
+			if err != nil {
+				return fmt.Errorf("bad frames range %q", r)
+			}
+		}
+		for n := a; n <= b; n++ {
+			(*s)[n] = true
+		}
+	}
+	return nil
+}
+
+// formatFrames joins sorted frame numbers into ranges: 1-500,502
+func formatFrames(frames []int) string {
+	var ranges []string
+	for i := 0; i < len(frames); {
+		j := i
+		for j+1 < len(frames) && frames[j+1] == frames[j]+1 {
+			j++
+		}
+		if j == i {
+			ranges = append(ranges, fmt.Sprint(frames[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", frames[i], frames[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/meta"
+)
+
+// chdirTemp runs the test in a temp dir, the output paths are relative
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	err = os.MkdirAll("tmp", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteFrameLastFirst(t *testing.T) {
+	chdirTemp(t)
+	const frameSize = 10
+	frames := [][]byte{
+		bytes.Repeat([]byte{1}, frameSize),
+		bytes.Repeat([]byte{2}, frameSize),
+		{3, 3, 3, 3},
+	}
+	out, err := openOutput(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := meta.New("file.bin")
+	md.SetTotal(len(frames))
+	// the last frame comes first, from a capture or another volume
+	for _, n := range []int{3, 1, 2} {
+		md.SetFrame(n)
+		err = out.WriteFrame(md, n, frames[n-1], frameSize, true)
+		if err != nil {
+			t.Fatalf("frame %d: %v", n, err)
+		}
+	}
+	err = out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.journal.Size != 2*frameSize+4 {
+		t.Fatalf("size %d, want %d", out.journal.Size, 2*frameSize+4)
+	}
+	got, err := os.ReadFile(cfg.PathDecodePart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Join(frames, nil); !bytes.Equal(got, want) {
+		t.Fatalf("output %v, want %v", got, want)
+	}
+}
+
+// TestWriteFrameLastBroken writes the last frame with a broken header, all its cells,
+// the size is unknown until the frame is written again with a readable header
+func TestWriteFrameLastBroken(t *testing.T) {
+	chdirTemp(t)
+	const frameSize = 10
+	out, err := openOutput(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := meta.New("file.bin")
+	md.SetTotal(2)
+	md.SetFrame(1)
+	err = out.WriteFrame(md, 1, bytes.Repeat([]byte{1}, frameSize), frameSize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = out.WriteFrame(meta.Metadata{}, 2, bytes.Repeat([]byte{2}, frameSize+5), frameSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.journal.Size != 0 {
+		t.Fatalf("size %d from a broken header, want unknown", out.journal.Size)
+	}
+
+	// another copy of the frame
+	md.SetFrame(2)
+	err = out.WriteFrame(md, 2, []byte{2, 2, 2}, frameSize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.journal.Size != frameSize+3 {
+		t.Fatalf("size %d, want %d", out.journal.Size, frameSize+3)
+	}
+	err = out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1F47E/go-bitreel/internal/meta"
+	"github.com/1F47E/go-bitreel/internal/pdf"
+	"github.com/1F47E/go-bitreel/internal/storage"
+	"github.com/1F47E/go-bitreel/internal/tui"
+)
+
+// writePDF puts the encoded frames on A4 pages, one per page,
+// with the reel info and the page number printed in plain text
+func (c *Core) writePDF(out string, summary *meta.Summary) error {
+	frames, err := filepath.Glob("tmp/out/out_*.png")
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames to save")
+	}
+	w, err := pdf.Create(out)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", out, err)
+	}
+	header := fmt.Sprintf("bitreel | %s | %d bytes | %s | block %d",
+		summary.Filename, summary.Size, time.Unix(summary.Timestamp, 0).UTC().Format(time.RFC822), summary.Settings.Block)
+	c.eventsCh <- tui.NewEventStage("Saving pages")
+	for i, frame := range frames {
+		if c.ctx.Err() != nil {
+			w.Close(summary.Filename)
+			return c.ctx.Err()
+		}
+		c.eventsCh <- tui.NewEventProgress(i+1, len(frames), 0)
+		img, err := storage.FrameRead(frame)
+		if err != nil {
+			w.Close(summary.Filename)
+			return err
+		}
+		footer := fmt.Sprintf("page %d of %d | sha256 %s", i+1, len(frames), summary.SHA256)
+		err = w.AddPage(img, header, footer)
+		if err != nil {
+			w.Close(summary.Filename)
+			return fmt.Errorf("error writing page %d: %w", i+1, err)
+		}
+	}
+	return w.Close(summary.Filename)
+}
+
+// readPDF extracts the page images into the frames dir, ok is false if the input is not a PDF
+func readPDF(path, dir string) (frames []string, ok bool, err error) {
+	if !strings.EqualFold(filepath.Ext(path), ".pdf") {
+		return nil, false, nil
+	}
+	images, err := pdf.Images(path)
+	if err != nil {
+		return nil, true, err
+	}
+	for i, img := range images {
+		frame := filepath.Join(dir, fmt.Sprintf("out_%08d.png", i+1))
+		err = storage.SaveImage(frame, img)
+		if err != nil {
+			return nil, true, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, true, nil
+}
@@ -0,0 +1,18 @@
+package core
+
+import (
+	"github.com/1F47E/go-bitreel/internal/tui"
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// videoProgress reports the video backend progress of the current stage with the fps and the speed
+// it reports, the ETA is taken from them, total is the expected frames count, 0 if unknown
+func (c *Core) videoProgress(total int) func(video.Progress) {
+	return func(p video.Progress) {
+		done := p.Frame
+		if total > 0 && (done > total || p.Done) {
+			done = total
+		}
+		c.eventsCh <- tui.NewEventVideoProgress(done, total, p.FPS, p.Speed)
+	}
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+
+	"github.com/1F47E/go-bitreel/internal/job"
+)
+
+// reorderWindow puts the frames decoded out of order back in order for the writer.
+// The feeder takes a slot for every frame and the writer frees it when the frame is written,
+// so at most size frames are decoded or waiting at once, whatever the reel length.
+// The frame the writer waits for is always in the window, the feeder sends frames in order.
+type reorderWindow struct {
+	slots chan struct{}
+	// decoded frames ahead of the next one, writer side only
+	pending map[int]job.JobDecRes
+	next    int
+}
+
+func newReorderWindow(size int) *reorderWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &reorderWindow{
+		slots:   make(chan struct{}, size),
+		pending: make(map[int]job.JobDecRes, size),
+	}
+}
+
+// acquire blocks the feeder until a slot is free, false if cancelled
+func (w *reorderWindow) acquire(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case w.slots <- struct{}{}:
+		return true
+	}
+}
+
+// release frees the slot of a written frame
+func (w *reorderWindow) release() {
+	<-w.slots
+}
+
+// put keeps the result until its turn and returns the results ready to write, in order
+func (w *reorderWindow) put(res job.JobDecRes) []job.JobDecRes {
+	w.pending[res.Idx] = res
+	var ready []job.JobDecRes
+	for {
+		r, ok := w.pending[w.next]
+		if !ok {
+			return ready
+		}
+		delete(w.pending, w.next)
+		ready = append(ready, r)
+		w.next++
+	}
+}
+
+// done reports if all the frames are out
+func (w *reorderWindow) done(total int) bool {
+	return w.next >= total
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-bitreel/internal/job"
+)
+
+func TestReorderWindowOrder(t *testing.T) {
+	w := newReorderWindow(4)
+	tests := []struct {
+		idx   int
+		ready []int
+	}{
+		{2, nil},
+		{1, nil},
+		{0, []int{0, 1, 2}},
+		{4, nil},
+		{3, []int{3, 4}},
+		{5, []int{5}},
+	}
+	for _, tt := range tests {
+		var ready []int
+		for _, r := range w.put(job.JobDecRes{Idx: tt.idx}) {
+			ready = append(ready, r.Idx)
+		}
+		if !reflect.DeepEqual(ready, tt.ready) {
+			t.Fatalf("put %d: ready %v, want %v", tt.idx, ready, tt.ready)
+		}
+	}
+	if !w.done(6) || w.done(7) {
+		t.Fatal("done is wrong after 6 frames")
+	}
+	if len(w.pending) != 0 {
+		t.Fatalf("%d frames are still pending", len(w.pending))
+	}
+}
+
+func TestReorderWindowBackpressure(t *testing.T) {
+	const size = 3
+	w := newReorderWindow(size)
+	ctx := context.Background()
+	for i := 0; i < size; i++ {
+		if !w.acquire(ctx) {
+			t.Fatalf("slot %d is not acquired", i)
+		}
+	}
+
+	// the window is full, the feeder waits for the writer
+	acquired := make(chan bool)
+	go func() {
+		acquired <- w.acquire(ctx)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot of a full window")
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.release()
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Fatal("slot is not acquired")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("released slot is not acquired")
+	}
+
+	// cancelled while waiting
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		acquired <- w.acquire(ctx)
+	}()
+	cancel()
+	select {
+	case ok := <-acquired:
+		if ok {
+			t.Fatal("acquired a slot of a full window after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancel does not unblock the feeder")
+	}
+}
+
+func TestReorderWindowSize(t *testing.T) {
+	// at least one slot, the frame the writer waits for
+	w := newReorderWindow(0)
+	if cap(w.slots) != 1 {
+		t.Fatalf("%d slots, want 1", cap(w.slots))
+	}
+}
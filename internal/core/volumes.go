@@ -0,0 +1,334 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/1F47E/go-bitreel/internal/audio"
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/encoder"
+	"github.com/1F47E/go-bitreel/internal/gifstream"
+	"github.com/1F47E/go-bitreel/internal/meta"
+	"github.com/1F47E/go-bitreel/internal/pdf"
+	"github.com/1F47E/go-bitreel/internal/storage"
+	"github.com/1F47E/go-bitreel/internal/tui"
+	"github.com/1F47E/go-bitreel/internal/video"
+)
+
+// splitVolumes returns the frames per volume and the volumes count by the limits,
+// 1 volume if the reel fits. frameBytes is the size of a frame in the output, see frameOutputSize.
+func splitVolumes(frames int, frameBytes int64, opts EncodeOptions) (int, int, error) {
+	if opts.MaxFrames < 0 || opts.MaxSize < 0 {
+		return 0, 0, fmt.Errorf("invalid volume limits")
+	}
+	perVolume := frames
+	if opts.MaxFrames > 0 && opts.MaxFrames < perVolume {
+		perVolume = opts.MaxFrames
+	}
+	if opts.MaxSize > 0 {
+		if frameBytes <= 0 {
+			return 0, 0, fmt.Errorf("unknown frame size")
+		}
+		n := int(opts.MaxSize / frameBytes)
+		if n == 0 {
+			return 0, 0, fmt.Errorf("max size %d is less than a frame of %d bytes", opts.MaxSize, frameBytes)
+		}
+		if n < perVolume {
+			perVolume = n
+		}
+	}
+	if perVolume == 0 {
+		return frames, 1, nil
+	}
+	volumes := (frames + perVolume - 1) / perVolume
+	if volumes > math.MaxUint16 {
+		return 0, 0, fmt.Errorf("too many volumes: %d, max %d", volumes, math.MaxUint16)
+	}
+	if volumes <= 1 {
+		return frames, 1, nil
+	}
+	return perVolume, volumes, nil
+}
+
+// frameOutputSize encodes a frame of random data into the output format and returns its size
+// with the audio of a frame. Random data is the worst case, scrambled and compressed files look like it.
+func (c *Core) frameOutputSize(backend video.Backend, enc *encoder.FrameEncoder, opts EncodeOptions) (int64, error) {
+	dir, err := os.MkdirTemp("", "bitreel-size-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, enc.Capacity()-cfg.SizeMetadata)
+	mathrand.New(mathrand.NewSource(1)).Read(data)
+	md := meta.New("frame")
+	md.SetFrame(1)
+	md.SetTotal(1)
+	md.SetSize(len(data))
+	img, err := enc.EncodeFrame(data, md)
+	if err != nil {
+		return 0, err
+	}
+	frame := filepath.Join(dir, "frame.png")
+	err = storage.SaveImage(frame, img)
+	if err != nil {
+		return 0, err
+	}
+
+	out := filepath.Join(dir, "frame")
+	switch opts.Format {
+	case FormatImages:
+		out = frame
+	case FormatPDF:
+		w, err := pdf.Create(out)
+		if err != nil {
+			return 0, err
+		}
+		err = w.AddPage(img, "", "")
+		if err != nil {
+			return 0, err
+		}
+		err = w.Close("")
+		if err != nil {
+			return 0, err
+		}
+	case FormatGIF:
+		w, err := gifstream.Create(out, gifDelay)
+		if err != nil {
+			return 0, err
+		}
+		err = w.WriteFrame(img)
+		if err != nil {
+			w.Close()
+			return 0, err
+		}
+		err = w.Close()
+		if err != nil {
+			return 0, err
+		}
+	default:
+		size, err := backend.FrameSize(c.ctx, frame)
+		if err != nil {
+			return 0, fmt.Errorf("error measuring the frame size: %w", err)
+		}
+		if opts.Audio {
+			// 16 bit PCM
+			size += int64(audio.SampleRate * 2 / video.Framerate)
+		}
+		return size, nil
+	}
+	fi, err := os.Stat(out)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// volumePath numbers the output before the extension, reel.mov -> reel.001.mov
+func volumePath(out string, volume int) string {
+	ext := filepath.Ext(out)
+	return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(out, ext), volume, ext)
+}
+
+// hashFile returns the sha256 of the file and rewinds it
+func hashFile(file *os.File) (string, error) {
+	digest := sha256.New()
+	_, err := io.Copy(digest, file)
+	if err != nil {
+		return "", fmt.Errorf("error reading file: %w", err)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// newSetID returns a random non zero set ID
+func newSetID() (uint64, error) {
+	var b [8]byte
+	for {
+		_, err := rand.Read(b[:])
+		if err != nil {
+			return 0, err
+		}
+		if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+			return id, nil
+		}
+	}
+}
+
+// expandVolumes replaces a dir of video volumes with the files in it,
+// a dir of frames stays as is
+func expandVolumes(inputs []string) ([]string, error) {
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("nothing to decode")
+	}
+	if len(inputs) > 1 {
+		return inputs, nil
+	}
+	fi, err := os.Stat(inputs[0])
+	if err != nil || !fi.IsDir() {
+		return inputs, nil
+	}
+	if _, err := storage.ListFrames(inputs[0]); err == nil {
+		return inputs, nil
+	}
+	entries, err := os.ReadDir(inputs[0])
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		// sidecars and hidden files are not volumes
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(inputs[0], e.Name()))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no frames or volumes in %s", inputs[0])
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readInputsImages collects the frames of all the inputs, every input is extracted to its own dir.
+// ok is false if the inputs are videos, an error if images and videos are mixed.
+func (c *Core) readInputsImages(inputs []string) (frames []string, ok bool, err error) {
+	var videos int
+	for i, input := range inputs {
+		dir, err := storage.CreateFramesDir()
+		if len(inputs) > 1 {
+			dir, err = storage.CreateVolumeDir(i + 1)
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		list, isImages, err := readImages(input, dir)
+		if !isImages {
+			videos++
+			continue
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		frames = append(frames, list...)
+	}
+	if videos == len(inputs) {
+		return nil, false, nil
+	}
+	if videos > 0 {
+		return nil, true, fmt.Errorf("cannot decode videos and images together")
+	}
+	return frames, true, nil
+}
+
+// decodeVolumes orders the video volumes by the headers, checks all of them are there
+// and decodes them one by one into a single file.
+// On resume some volumes can be missing, they are decoded later,
+// and the volumes already in the output are skipped.
+func (c *Core) decodeVolumes(backend video.Backend, inputs []string, opts DecodeOptions, out *sparseOutput) (string, error) {
+	resume := opts.Resume
+	volumes := make(map[int]string)
+	var first *meta.Summary
+	for _, input := range inputs {
+		s, _, err := c.Info(input, DecodeOptions{Backend: backend.Name()})
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", input, err)
+		}
+		if s.Volumes == 0 {
+			if len(inputs) > 1 {
+				return "", fmt.Errorf("%s is not a volume of a split reel", input)
+			}
+			// single reel on resume
+			s.Volume, s.Volumes = 1, 1
+		}
+		if first == nil {
+			first = s
+		} else if s.Set != first.Set {
+			return "", fmt.Errorf("%s is from another set %s, expected %s", input, s.Set, first.Set)
+		}
+		if prev, ok := volumes[s.Volume]; ok {
+			return "", fmt.Errorf("volume %d is both %s and %s", s.Volume, prev, input)
+		}
+		volumes[s.Volume] = input
+	}
+	have := make(map[int]bool)
+	for v := range volumes {
+		have[v] = true
+	}
+	if missing := missingVolumes(have, first.Volumes); missing != "" && !resume {
+		return "", fmt.Errorf("missing volumes of %s: %s of %d", first.Filename, missing, first.Volumes)
+	}
+
+	var metadata meta.Metadata
+	for v := 1; v <= first.Volumes; v++ {
+		file, ok := volumes[v]
+		if !ok {
+			continue
+		}
+		firstFrame := 1 + (v-1)*first.VolumeFrames
+		if resume && volumeWritten(out, first, v) {
+			c.eventsCh <- tui.NewEventText(fmt.Sprintf("Volume %d/%d is already decoded: %s", v, first.Volumes, file))
+			continue
+		}
+		c.eventsCh <- tui.NewEventText(fmt.Sprintf("Decoding volume %d/%d: %s", v, first.Volumes, file))
+		dir, err := storage.CreateVolumeDir(v)
+		if err != nil {
+			return "", err
+		}
+		md, err := c.decodeVideo(backend, file, dir, firstFrame, opts, out)
+		if err != nil {
+			return "", fmt.Errorf("volume %d: %w", v, err)
+		}
+		if md.IsOk() && !metadata.IsOk() {
+			metadata = md
+		}
+		// frames of the decoded volume are not needed anymore
+		err = os.RemoveAll(dir)
+		if err != nil {
+			return "", err
+		}
+	}
+	return c.saveDecoded(out, metadata)
+}
+
+// volumeWritten reports if all the frames of the volume are verified in the output
+func volumeWritten(out *sparseOutput, s *meta.Summary, volume int) bool {
+	from, to := 1, s.Frames
+	if s.VolumeFrames > 0 {
+		from = 1 + (volume-1)*s.VolumeFrames
+		to = volume * s.VolumeFrames
+		if to > s.Frames {
+			to = s.Frames
+		}
+	}
+	for n := from; n <= to; n++ {
+		if !out.Verified(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// missingVolumes lists the volumes from 1 to total that are not there, empty if none
+func missingVolumes(have map[int]bool, total int) string {
+	var missing []string
+	for v := 1; v <= total; v++ {
+		if !have[v] {
+			missing = append(missing, fmt.Sprint(v))
+		}
+	}
+	return strings.Join(missing, ", ")
+}
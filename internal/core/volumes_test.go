@@ -0,0 +1,36 @@
+package core
+
+import "testing"
+
+func TestSplitVolumes(t *testing.T) {
+	tests := []struct {
+		name       string
+		frames     int
+		frameBytes int64
+		opts       EncodeOptions
+		perVolume  int
+		volumes    int
+	}{
+		{"no limits", 10, 0, EncodeOptions{}, 10, 1},
+		{"by frames", 10, 0, EncodeOptions{MaxFrames: 4}, 4, 3},
+		{"by size", 10, 1000, EncodeOptions{MaxSize: 3500}, 3, 4},
+		{"smaller of both", 10, 1000, EncodeOptions{MaxFrames: 2, MaxSize: 3500}, 2, 5},
+		{"fits", 3, 1000, EncodeOptions{MaxSize: 3500}, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perVolume, volumes, err := splitVolumes(tt.frames, tt.frameBytes, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perVolume != tt.perVolume || volumes != tt.volumes {
+				t.Fatalf("%d frames in %d volumes, want %d in %d", perVolume, volumes, tt.perVolume, tt.volumes)
+			}
+		})
+	}
+
+	_, _, err := splitVolumes(10, 1000, EncodeOptions{MaxSize: 999})
+	if err == nil {
+		t.Fatal("no error for a limit smaller than a frame")
+	}
+}
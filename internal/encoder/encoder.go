@@ -1,171 +1,476 @@
 package encoder
 
 import (
-	// cfg "github.com/1F47E/go-bitreel/internal/config"
+	"fmt"
 	"image"
-	"image/color"
+	"math"
+	"sync"
 
+	cfg "github.com/1F47E/go-bitreel/internal/config"
 	"github.com/1F47E/go-bitreel/internal/logger"
 	"github.com/1F47E/go-bitreel/internal/meta"
 	"github.com/1F47E/go-bitreel/internal/storage"
 )
 
-const pixelSize = 4
+// frames are grayscale, 1 byte per pixel
+const (
+	grayBlack = 0
+	grayWhite = 255
+)
 
 type FrameEncoder struct {
 	width    int
 	height   int
+	block    int
+	layout   *layout
 	sizeBits int
+
+	// decoder detects the block size per frame
+	mu      sync.Mutex
+	layouts map[int]*layout
+
+	// module colors of the quiet zone, finder and timing patterns, copied into every frame
+	patternOnce sync.Once
+	pattern     []uint8
+	// frame images returned by ReleaseFrame
+	frames sync.Pool
+}
+
+// NewFrameEncoder creates an encoder for width x height frames
+// where every bit is a block x block pixels square
+func NewFrameEncoder(width, height, block int) (*FrameEncoder, error) {
+	if !isValidBlock(width, height, block) {
+		return nil, fmt.Errorf("invalid block size %d for %dx%d frame, valid sizes: %v", block, width, height, Blocks(width, height))
+	}
+	l := newLayout(width, height, block)
+	return &FrameEncoder{
+		width:    width,
+		height:   height,
+		block:    block,
+		layout:   l,
+		sizeBits: len(l.cells),
+		layouts:  map[int]*layout{block: l},
+	}, nil
 }
 
-func NewFrameEncoder(width, height int) *FrameEncoder {
-	// calc frame size in bits based on our pixel size
-	sizeBits := width * height / pixelSize
-	return &FrameEncoder{width, height, sizeBits}
+// Blocks returns the block sizes that fit the frame evenly
+func Blocks(width, height int) []int {
+	var res []int
+	for b := 1; b <= width && b <= height; b++ {
+		if isValidBlock(width, height, b) {
+			res = append(res, b)
+		}
+	}
+	return res
 }
 
-func (f *FrameEncoder) EncodeFrame(data []byte, m meta.Metadata) *image.NRGBA {
+func isValidBlock(width, height, block int) bool {
+	if block < 1 || width%block != 0 || height%block != 0 {
+		return false
+	}
+	// room for the finder patterns and at least some data
+	min := 2 * (quietModules + finderZone + 1)
+	return width/block >= min && height/block >= min
+}
+
+// Size returns the frame size in pixels
+func (f *FrameEncoder) Size() (int, int) {
+	return f.width, f.height
+}
+
+// Closest returns the encoder with the frame aspect ratio closest to the image
+func Closest(encs []*FrameEncoder, width, height int) *FrameEncoder {
+	ratio := float64(width) / float64(height)
+	var best *FrameEncoder
+	bestDiff := math.Inf(1)
+	for _, enc := range encs {
+		diff := math.Abs(math.Log(ratio * float64(enc.height) / float64(enc.width)))
+		if diff < bestDiff {
+			best, bestDiff = enc, diff
+		}
+	}
+	return best
+}
+
+// Capacity returns the amount of bytes a frame can hold, including metadata
+func (f *FrameEncoder) Capacity() int {
+	return f.sizeBits / 8
+}
+
+// EncodeFrame paints the header and the data into a grayscale frame, every bit is a data cell,
+// black = 1, white = 0, the cells after the data are white, the header has the data size.
+// The frame can be given back with ReleaseFrame when it is saved.
+func (f *FrameEncoder) EncodeFrame(data []byte, m meta.Metadata) (*image.Gray, error) {
 	log := logger.Log.WithField("scope", "frame encoder")
 	log.Debug("Encoding frame")
 
-	// craete buffer, get and copy metadata - filename, timestamp and checksum
-	bufferBits := make([]bool, f.sizeBits)
-	metadataBits, err := m.Hash(data)
+	// get and copy metadata - filename, timestamp and checksum
+	header, err := m.Hash(data)
 	if err != nil {
-		log.Fatal("Cannot hash metadata:", err)
-	}
-	copy(bufferBits, metadataBits)
-
-	// range over data bit by bit and encode every bit as a pixel
-	var bitIndex int
-	for i := 0; i < len(data); i++ {
-		for k := 0; k < 8; k++ {
-			// shift by metadata size header
-			bitIndex = len(metadataBits) + i*8 + k
-			// get the current bit of the byte
-			// buf[i]:     0 1 1 0 1 0 0 1
-			// (1<<j):     0 0 0 0 1 0 0 0  (1 has been shifted left 3 times)
-			//					   ^
-			// --------------------------------
-			// result:     0 0 0 0 1 0 0 0  (bitwise AND operation)
-			//					   ^
-			// write the bit to the buffer
-			bufferBits[bitIndex] = (data[i] & (1 << uint(k))) != 0
-		}
-	}
-
-	// generate image
-	writeIdx := 0
-	var col color.Color
-	img := image.NewNRGBA(image.Rect(0, 0, f.width, f.height))
-	for x := 0; x < f.width; x += 2 {
-		for y := 0; y < f.height; y += 2 {
-			// detect file end
-			if writeIdx <= bitIndex {
-				if bufferBits[writeIdx] {
-					col = color.NRGBA{0, 0, 0, 255} // black
-				} else {
-					col = color.NRGBA{255, 255, 255, 255} // white
-				}
-			} else {
-				col = color.NRGBA{255, 0, 0, 255} // red
-			}
-			// Set a 2x2 block of pixels to the color.
-			img.Set(x, y, col)
-			img.Set(x+1, y, col)
-			img.Set(x, y+1, col)
-			img.Set(x+1, y+1, col)
-			writeIdx++
+		return nil, fmt.Errorf("cannot hash metadata: %w", err)
+	}
+
+	// header stays as is, decoder needs it to restore the data
+	if m.HasFlag(meta.FlagScrambled) {
+		data = scramble(data, m.Frame())
+	}
+	data = interleave(data, m.Interleave())
+	if len(header)+len(data) > f.Capacity() {
+		return nil, fmt.Errorf("%d bytes do not fit the frame of %d bytes", len(header)+len(data), f.Capacity())
+	}
+
+	// module colors first, pixels are painted a module row at a time
+	l := f.layout
+	modules := f.newModules()
+	// bit k of the byte i is the cell i*8+k, header first
+	headerBits := len(header) * 8
+	dataBits := headerBits + len(data)*8
+	for idx, cell := range l.cells {
+		switch {
+		case idx < headerBits:
+			modules[cell] = bitColor(header[idx>>3], idx&7)
+		case idx < dataBits:
+			modules[cell] = bitColor(data[(idx-headerBits)>>3], idx&7)
+		default:
+			modules[cell] = grayWhite
 		}
 	}
+	img := f.newFrame()
+	f.paint(img, modules)
 
 	log.Debug("Encoding frame done")
+	return img, nil
+}
+
+// ReleaseFrame gives the frame back to be reused by the next EncodeFrame
+func (f *FrameEncoder) ReleaseFrame(img *image.Gray) {
+	if img == nil || img.Rect != image.Rect(0, 0, f.width, f.height) {
+		return
+	}
+	f.frames.Put(img)
+}
+
+// newFrame returns a released frame or a new one, all the pixels are painted over
+func (f *FrameEncoder) newFrame() *image.Gray {
+	img, ok := f.frames.Get().(*image.Gray)
+	if !ok {
+		img = image.NewGray(image.Rect(0, 0, f.width, f.height))
+	}
 	return img
 }
 
-func (f *FrameEncoder) DecodeFrame(filename string) ([]byte, int) {
+// newModules returns the module colors with the patterns, data cells are to be set
+func (f *FrameEncoder) newModules() []uint8 {
+	l := f.layout
+	f.patternOnce.Do(func() {
+		f.pattern = make([]uint8, l.cols*l.rows)
+		for r := 0; r < l.rows; r++ {
+			for c := 0; c < l.cols; c++ {
+				f.pattern[r*l.cols+c] = grayWhite
+				if l.module(c, r) == moduleBlack {
+					f.pattern[r*l.cols+c] = grayBlack
+				}
+			}
+		}
+	})
+	return append([]uint8(nil), f.pattern...)
+}
+
+func bitColor(b byte, k int) uint8 {
+	if b&(1<<uint(k)) != 0 {
+		return grayBlack
+	}
+	return grayWhite
+}
+
+// paint the modules as blocks, the first pixel row of a module row is painted
+// and copied to the rest of the block rows
+func (f *FrameEncoder) paint(img *image.Gray, modules []uint8) {
+	l := f.layout
+	stride := img.Stride
+	for r := 0; r < l.rows; r++ {
+		y0 := r * f.block * stride
+		line := img.Pix[y0 : y0+f.width]
+		o := 0
+		for _, m := range modules[r*l.cols : (r+1)*l.cols] {
+			for x := 0; x < f.block; x++ {
+				line[o] = m
+				o++
+			}
+		}
+		for y := 1; y < f.block; y++ {
+			copy(img.Pix[y0+y*stride:], line)
+		}
+	}
+}
+
+// DecodeFrame decodes a frame extracted from the video.
+// Returns all the cells and the header and data length, all the bytes if the header is broken,
+// and the count of the corrected pixel errors - cells read close to the black/white threshold.
+// A frame without the grid is not an error, it has no bytes,
+// unless it is a frame of an older reel, see meta.ErrLegacy.
+func (f *FrameEncoder) DecodeFrame(filename string) ([]byte, int, int, error) {
+	bytes, writtenBytes, _, corrected, err := f.decode(filename)
+	return bytes, writtenBytes, corrected, err
+}
+
+// DecodeCapture decodes a frame from a camera or screen capture.
+// Also returns the capture sharpness from 0 to 1,
+// blurred captures have block samples close to the black/white threshold.
+func (f *FrameEncoder) DecodeCapture(filename string) ([]byte, int, float64, error) {
+	bytes, writtenBytes, sharpness, _, err := f.decode(filename)
+	return bytes, writtenBytes, sharpness, err
+}
+
+func (f *FrameEncoder) decode(filename string) ([]byte, int, float64, int, error) {
 	log := logger.Log.WithField("scope", "frame decoder")
 	img, err := storage.FrameRead(filename)
 	if err != nil {
-		log.Fatal("Cannot decode file:", err)
+		return nil, 0, 0, 0, fmt.Errorf("cannot read frame: %w", err)
+	}
+
+	g, err := f.locate(img)
+	if err != nil {
+		if name, ok := legacyHeader(img); ok {
+			return nil, 0, 0, 0, fmt.Errorf("%w, file %s", meta.ErrLegacy, name)
+		}
+		log.Warnf("Cannot locate frame grid in %s: %v", filename, err)
+		return nil, 0, 0, 0, nil
 	}
 
 	// copy image to bytes
-	// black = 1, white = 0, red = EOF
-	var writeIdx int
-	var cntBlack, cntWhite, cntRed uint
+	// black = 1, white = 0
+	// sampling the center of every block, the value close to the threshold is a corrected error
+	// bits are packed as they are read, bit k of the byte i is the cell i*8+k
 	var pixelErrorsCount int
-	// var fileBits [cfg.sizeBits]bool
-	fileBits := make([]bool, f.sizeBits)
-	for x := 0; x < f.width; x += 2 {
-		for y := 0; y < f.height; y += 2 {
-			// error detection
-			// count black and white pixels in a 2x2 square
-			for i := 0; i < 2; i++ {
-				for j := 0; j < 2; j++ {
-					col := img.At(x+i, y+j)
-					// this will return 0-65535 range
-					r, g, b, _ := col.RGBA()
-					// shift 8 bits to the right to have 0-255 range
-					r8, g8, b8 := r>>8, g>>8, b>>8
-
-					// detect red first
-					if r8 > 128 && g8 < 128 && b8 < 128 {
-						cntRed++
-					} else if r8 > 128 && g8 > 128 && b8 > 128 {
-						cntWhite++
-					} else {
-						cntBlack++
-					}
-				}
-			}
-
-			// skip if reached red section which is EOF
-			if cntRed > cntBlack && cntRed > cntWhite {
-				// reset counters
-				cntRed = 0
-				cntBlack = 0
-				cntWhite = 0
-				continue
+	var sharpness float64
+	var sampled int
+	l := g.layout
+	us, vs := g.axes()
+	bytes := make([]byte, len(l.cells)/8)
+	// the header has the data size, the cells after the data do not count as errors
+	counted := len(l.cells)
+	for idx, cell := range l.cells {
+		if idx == cfg.SizeMetadata*8 {
+			if m, err := meta.Parse(bytes[:cfg.SizeMetadata]); err == nil {
+				counted = (cfg.SizeMetadata + m.Size()) * 8
 			}
+		}
+		c, r := cell%l.cols, cell/l.cols
+		x, y := g.h.apply(float64(c)+0.5, float64(r)+0.5)
+		lum := g.sample(img, x, y)
 
-			if cntBlack > cntWhite {
-				fileBits[writeIdx] = true
-				if cntBlack != 4 {
-					// fmt.Println("Error at black ", x, y, cntBlack, cntWhite)
-					pixelErrorsCount++
-				}
-			} else {
-				fileBits[writeIdx] = false
-				if cntWhite != 4 {
-					// fmt.Println("Error at white ", x, y, cntBlack, cntWhite)
-					pixelErrorsCount++
-				}
-			}
-			cntRed = 0
-			cntBlack = 0
-			cntWhite = 0
-			writeIdx++
+		th, margin := g.threshold(us[c], vs[r])
+		if lum < th && idx>>3 < len(bytes) {
+			bytes[idx>>3] |= 1 << uint(idx&7)
 		}
+		if idx >= counted {
+			continue
+		}
+		if math.Abs(lum-th) < margin {
+			pixelErrorsCount++
+		}
+		sharpness += math.Min(math.Abs(lum-th)/(2*margin), 1)
+		sampled++
 	}
 	if pixelErrorsCount > 0 {
-		log.Println()
-		log.Warnf("Pixel errors (%d) corrected in frame: %s\n", pixelErrorsCount, filename)
-	}
-
-	// 4k video is 3840x2160 = 8294400 pixels = 2073600 4px blocks
-	// every frame should have 2073600 bits
-	// convert bits to bytes
-	bytes := make([]byte, len(fileBits)/8)
-	for i := 0; i < len(fileBits); i += 8 {
-		var b byte
-		for j := 0; j < 8; j++ {
-			if fileBits[i+j] {
-				b |= 1 << uint(j)
+		log.Debugf("Pixel errors (%d) corrected in frame: %s", pixelErrorsCount, filename)
+	}
+	if sampled > 0 {
+		sharpness /= float64(sampled)
+	}
+
+	writtenBytes := restore(bytes)
+	return bytes, writtenBytes, sharpness, pixelErrorsCount, nil
+}
+
+// restore deinterleaves and descrambles the data after the header,
+// in place, with the settings and the data size from the header.
+// Returns the header and data length, all the bytes if the header is broken.
+func restore(bytes []byte) int {
+	if len(bytes) < cfg.SizeMetadata {
+		return len(bytes)
+	}
+	m, err := meta.Parse(bytes[:cfg.SizeMetadata])
+	if err != nil || cfg.SizeMetadata+m.Size() > len(bytes) {
+		return len(bytes)
+	}
+	end := cfg.SizeMetadata + m.Size()
+	Restore(bytes[cfg.SizeMetadata:end], m)
+	return end
+}
+
+// Restore deinterleaves and descrambles the frame data in place.
+// Decoder does it with the frame header, this is for the frames
+// with a broken header when the settings are known from elsewhere.
+func Restore(data []byte, m meta.Metadata) {
+	copy(data, deinterleave(data, m.Interleave()))
+	if m.HasFlag(meta.FlagScrambled) {
+		copy(data, scramble(data, m.Frame()))
+	}
+}
+
+// grid of the frame found in the image
+type grid struct {
+	layout *layout
+	h      homography
+	module float64 // module size in pixels
+	// black and white luminance at the finders, same order as layout.finders
+	black [4]float64
+	white [4]float64
+}
+
+// locate finds the finder patterns, block size and the transform
+// from the frame modules to the image pixels.
+// The image can be scaled, letterboxed, rotated or captured at an angle.
+func (f *FrameEncoder) locate(img image.Image) (*grid, error) {
+	p := newPlane(img)
+	th := p.threshold()
+	finders, err := locateFinders(p, th)
+	if err != nil {
+		return nil, err
+	}
+	var centers [4]point
+	var measured float64
+	for i, fp := range finders {
+		centers[i] = fp.center
+		measured += fp.module / 4
+	}
+
+	// try every block size, the right one matches the timing patterns
+	var best *grid
+	var bestScore float64
+	for _, block := range Blocks(f.width, f.height) {
+		l := f.layoutFor(block)
+		src := l.finders()
+		// module size predicted by the finders distance should match the measured one
+		predicted := math.Hypot(centers[1].x-centers[0].x, centers[1].y-centers[0].y) / (src[1].x - src[0].x)
+		if predicted < 1 || predicted > measured*1.5 || predicted < measured/1.5 {
+			continue
+		}
+		h, err := newHomography(src, centers)
+		if err != nil {
+			return nil, err
+		}
+		score := timingScore(p, l, &h, th)
+		if score > bestScore {
+			bestScore = score
+			best = &grid{layout: l, h: h, module: predicted}
+		}
+	}
+	if best == nil || bestScore < 0.8 {
+		return nil, fmt.Errorf("block size not detected")
+	}
+
+	// black and white levels from the finder center and the white ring around it,
+	// captured frames are lit unevenly so every corner has its own levels
+	for i, c := range best.layout.finders() {
+		x, y := best.h.apply(c.x, c.y)
+		best.black[i] = float64(p.at(int(x), int(y)))
+		x, y = best.h.apply(c.x-2, c.y)
+		best.white[i] = float64(p.at(int(x), int(y)))
+		if best.white[i]-best.black[i] < 16 {
+			return nil, fmt.Errorf("frame contrast is too low")
+		}
+	}
+	return best, nil
+}
+
+// axes returns the module positions between the finders, 0 to 1, by column and by row
+func (g *grid) axes() ([]float64, []float64) {
+	l := g.layout
+	fs := l.finders()
+	us := make([]float64, l.cols)
+	for c := range us {
+		us[c] = clamp((float64(c)+0.5-fs[0].x)/(fs[1].x-fs[0].x), 0, 1)
+	}
+	vs := make([]float64, l.rows)
+	for r := range vs {
+		vs[r] = clamp((float64(r)+0.5-fs[0].y)/(fs[2].y-fs[0].y), 0, 1)
+	}
+	return us, vs
+}
+
+// threshold returns the luminance between black and white for the module at u, v of axes
+// and the margin, samples closer to the threshold are errors
+func (g *grid) threshold(u, v float64) (float64, float64) {
+	lerp := func(l [4]float64) float64 {
+		top := l[0] + (l[1]-l[0])*u
+		bottom := l[2] + (l[3]-l[2])*u
+		return top + (bottom-top)*v
+	}
+	black, white := lerp(g.black), lerp(g.white)
+	return (black + white) / 2, (white - black) / 4
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+func (f *FrameEncoder) layoutFor(block int) *layout {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l, ok := f.layouts[block]
+	if !ok {
+		l = newLayout(f.width, f.height, block)
+		f.layouts[block] = l
+	}
+	return l
+}
+
+// timingScore returns the ratio of timing pattern modules matching the expected colors
+func timingScore(p *plane, l *layout, h *homography, th uint8) float64 {
+	q := quietModules
+	pos := q + finderModules - 1
+	var total, match int
+	check := func(c, r int) {
+		x, y := h.apply(float64(c)+0.5, float64(r)+0.5)
+		dark := p.at(int(x), int(y)) < th
+		if dark == (l.module(c, r) == moduleBlack) {
+			match++
+		}
+		total++
+	}
+	for c := q + finderZone; c < l.cols-q-finderZone; c++ {
+		check(c, pos)
+	}
+	for r := q + finderZone; r < l.rows-q-finderZone; r++ {
+		check(pos, r)
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(match) / float64(total)
+}
+
+// sample averages the pixels of the center half of the block,
+// edges are blurred by scaling and codec ringing.
+// Returns the block luminance.
+func (g *grid) sample(img image.Image, x, y float64) float64 {
+	b := img.Bounds()
+	// 1 pixel for 2px blocks, 2x2 for 4px, 4x4 for 8px
+	half := g.module / 4
+	x0, x1 := int(x-half+0.5), int(x+half-0.5)
+	y0, y1 := int(y-half+0.5), int(y+half-0.5)
+	if x1 < x0 {
+		x0, x1 = int(x), int(x)
+	}
+	if y1 < y0 {
+		y0, y1 = int(y), int(y)
+	}
+	var sum, n int
+	for yy := y0; yy <= y1; yy++ {
+		for xx := x0; xx <= x1; xx++ {
+			px, py := b.Min.X+xx, b.Min.Y+yy
+			if !(image.Point{px, py}.In(b)) {
+				continue
 			}
+			sum += int(luma(rgbAt(img, px, py)))
+			n++
 		}
-		bytes[i/8] = b
 	}
-	writtenBytes := writeIdx / 8
-	return bytes, writtenBytes
+	if n == 0 {
+		return 255
+	}
+	return float64(sum) / float64(n)
 }
@@ -0,0 +1,225 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/meta"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, block := range []int{2, 4, 8, 16} {
+		enc, err := NewFrameEncoder(testWidth, testHeight, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, scrambled := range []bool{false, true} {
+			for _, depth := range []int{0, 16} {
+				// a full frame and the last frame of a file
+				full := enc.Capacity() - cfg.SizeMetadata
+				for _, size := range []int{full, full / 3} {
+					name := fmt.Sprintf("block=%d scrambled=%v interleave=%d size=%d", block, scrambled, depth, size)
+					t.Run(name, func(t *testing.T) {
+						m := testMeta(size)
+						if scrambled {
+							m.SetFlag(meta.FlagScrambled)
+						}
+						m.SetInterleave(depth)
+						data := testData(size, int64(size))
+						img, err := enc.EncodeFrame(data, m)
+						if err != nil {
+							t.Fatal(err)
+						}
+						cells, n, corrected, err := enc.DecodeFrame(saveTest(t, img))
+						if err != nil {
+							t.Fatal(err)
+						}
+						checkDecoded(t, cells, n, data)
+						if corrected != 0 {
+							t.Fatalf("%d corrected errors in a lossless frame", corrected)
+						}
+						// the cells after the data are white
+						if !bytes.Equal(cells[n:], make([]byte, len(cells)-n)) {
+							t.Fatal("cells after the data are not empty")
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+// TestEncodeFramePixels compares the frames with every module painted pixel by pixel,
+// as the frames were painted before the bits were packed and painted by rows
+func TestEncodeFramePixels(t *testing.T) {
+	for _, block := range []int{2, 8} {
+		enc, err := NewFrameEncoder(testWidth, testHeight, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := testMeta(0)
+		m.SetFlag(meta.FlagScrambled)
+		m.SetInterleave(16)
+		data := testData(enc.Capacity()/2, 2)
+		m.SetSize(len(data))
+
+		header, err := m.Hash(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := interleave(scramble(data, m.Frame()), m.Interleave())
+		want := referenceFrame(enc.layout, append(header, payload...))
+
+		// the released frame is painted over
+		first, err := enc.EncodeFrame(testData(len(data), 3), testMeta(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc.ReleaseFrame(first)
+		img, err := enc.EncodeFrame(data, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(img.Pix, want.Pix) {
+			t.Fatalf("block %d: frame differs from the reference", block)
+		}
+	}
+}
+
+// referenceFrame paints every pixel of every module on its own
+func referenceFrame(l *layout, bits []byte) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, l.cols*l.block, l.rows*l.block))
+	colors := make([]uint8, l.cols*l.rows)
+	for i := range colors {
+		colors[i] = grayWhite
+		if l.module(i%l.cols, i/l.cols) == moduleBlack {
+			colors[i] = grayBlack
+		}
+	}
+	for idx, cell := range l.cells {
+		if idx/8 < len(bits) && bits[idx/8]&(1<<uint(idx%8)) != 0 {
+			colors[cell] = grayBlack
+		}
+	}
+	for i, c := range colors {
+		x0, y0 := i%l.cols*l.block, i/l.cols*l.block
+		for y := y0; y < y0+l.block; y++ {
+			for x := x0; x < x0+l.block; x++ {
+				img.Pix[y*img.Stride+x] = c
+			}
+		}
+	}
+	return img
+}
+
+func BenchmarkEncodeFrame(b *testing.B) {
+	enc, err := NewFrameEncoder(cfg.FrameWidth, cfg.FrameHeight, cfg.FrameBlock)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := testMeta(0)
+	m.SetFlag(meta.FlagScrambled)
+	data := testData(enc.Capacity()-cfg.SizeMetadata, 1)
+	m.SetSize(len(data))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img, err := enc.EncodeFrame(data, m)
+		if err != nil {
+			b.Fatal(err)
+		}
+		enc.ReleaseFrame(img)
+	}
+}
+
+func BenchmarkDecodeFrame(b *testing.B) {
+	enc, err := NewFrameEncoder(cfg.FrameWidth, cfg.FrameHeight, cfg.FrameBlock)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := testMeta(0)
+	m.SetFlag(meta.FlagScrambled)
+	data, img := encodeTest(b, enc, m)
+	filename := saveTest(b, img)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, n, _, err := enc.DecodeFrame(filename)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != cfg.SizeMetadata+len(data) {
+			b.Fatalf("decoded %d bytes", n)
+		}
+	}
+}
+
+// TestDecodeLegacyFrame decodes a frame of a reel made before the finder patterns
+func TestDecodeLegacyFrame(t *testing.T) {
+	enc, err := NewFrameEncoder(cfg.FrameWidth, cfg.FrameHeight, cfg.FrameBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = enc.DecodeFrame(saveTest(t, legacyFrame("backup.tar", testData(1000, 1))))
+	if !errors.Is(err, meta.ErrLegacy) {
+		t.Fatalf("got error %v, want %v", err, meta.ErrLegacy)
+	}
+	if !strings.Contains(err.Error(), "backup.tar") {
+		t.Fatalf("error %q has no filename", err)
+	}
+
+	// a blank frame is not a reel of any format
+	_, n, _, err := enc.DecodeFrame(saveTest(t, legacyFrame("", nil)))
+	if err != nil || n != 0 {
+		t.Fatalf("blank frame: %d bytes, error %v", n, err)
+	}
+}
+
+// legacyFrame paints the frame as the older bitreel did: 2x2 blocks column by column,
+// the header is checksum, timestamp and the filename, red blocks after the data.
+// Blank frame without the filename.
+func legacyFrame(filename string, data []byte) *image.NRGBA {
+	var bits []byte
+	if filename != "" {
+		header := make([]byte, cfg.SizeMetadata)
+		h := fnv.New64a()
+		h.Write(data)
+		binary.BigEndian.PutUint64(header[0:8], h.Sum64())
+		binary.BigEndian.PutUint64(header[8:16], 1700000000)
+		copy(header[16:], filename+cfg.MetadataEOFMarker)
+		bits = append(header, data...)
+	}
+	black := color.NRGBA{0, 0, 0, 255}
+	white := color.NRGBA{255, 255, 255, 255}
+	red := color.NRGBA{255, 0, 0, 255}
+	img := image.NewNRGBA(image.Rect(0, 0, cfg.FrameWidth, cfg.FrameHeight))
+	idx := 0
+	for x := 0; x < cfg.FrameWidth; x += 2 {
+		for y := 0; y < cfg.FrameHeight; y += 2 {
+			col := white
+			if filename != "" {
+				col = red
+			}
+			if idx < len(bits)*8 {
+				col = white
+				if bits[idx>>3]&(1<<uint(idx&7)) != 0 {
+					col = black
+				}
+			}
+			img.SetNRGBA(x, y, col)
+			img.SetNRGBA(x+1, y, col)
+			img.SetNRGBA(x, y+1, col)
+			img.SetNRGBA(x+1, y+1, col)
+			idx++
+		}
+	}
+	return img
+}
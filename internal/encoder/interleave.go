@@ -0,0 +1,40 @@
+package encoder
+
+// Block interleaver, spreads consecutive bytes across the frame.
+// Data is written into depth rows by columns and read row by row,
+// so neighbour bytes end up len/depth bytes apart. A codec artifact
+// destroying a run of cells then damages bytes depth positions apart
+// instead of a contiguous run.
+func interleave(data []byte, depth int) []byte {
+	if depth < 2 || len(data) <= depth {
+		return data
+	}
+	res := make([]byte, len(data))
+	for i, b := range data {
+		res[interleaveSlot(i, len(data), depth)] = b
+	}
+	return res
+}
+
+func deinterleave(data []byte, depth int) []byte {
+	if depth < 2 || len(data) <= depth {
+		return data
+	}
+	res := make([]byte, len(data))
+	for i := range res {
+		res[i] = data[interleaveSlot(i, len(data), depth)]
+	}
+	return res
+}
+
+// interleaveSlot returns the position of byte i out of n.
+// First n%depth rows are one byte longer.
+func interleaveSlot(i, n, depth int) int {
+	row, col := i%depth, i/depth
+	short := n / depth
+	extra := row
+	if extra > n%depth {
+		extra = n % depth
+	}
+	return row*short + extra + col
+}
@@ -0,0 +1,78 @@
+package encoder
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestInterleave(t *testing.T) {
+	data := []byte{0, 1, 2, 3, 4, 5, 6}
+	// 7 bytes in 3 rows, the first row is one byte longer
+	want := []byte{0, 3, 6, 1, 4, 2, 5}
+	got := interleave(data, 3)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("interleaved %v, want %v", got, want)
+	}
+	if back := deinterleave(got, 3); !bytes.Equal(back, data) {
+		t.Fatalf("deinterleaved %v, want %v", back, data)
+	}
+}
+
+func TestInterleaveRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		depth int
+	}{
+		{"off", 100, 0},
+		{"depth 1", 100, 1},
+		{"depth over length", 10, 16},
+		{"depth equal length", 16, 16},
+		{"even rows", 96, 16},
+		{"uneven rows", 100, 16},
+		{"one byte rows", 17, 16},
+		{"large", 100000, 255},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testData(tt.n, int64(tt.n))
+			got := interleave(data, tt.depth)
+			if len(got) != len(data) {
+				t.Fatalf("interleaved %d bytes, want %d", len(got), len(data))
+			}
+			if tt.depth < 2 || tt.n <= tt.depth {
+				if !bytes.Equal(got, data) {
+					t.Fatal("data is interleaved, want as is")
+				}
+			}
+			if back := deinterleave(got, tt.depth); !bytes.Equal(back, data) {
+				t.Fatal("deinterleaved data differs")
+			}
+		})
+	}
+}
+
+// TestInterleaveSlot checks every byte gets a slot of its own
+func TestInterleaveSlot(t *testing.T) {
+	for _, n := range []int{1, 2, 15, 16, 17, 100} {
+		for _, depth := range []int{1, 2, 3, 16, 200} {
+			t.Run(fmt.Sprintf("n=%d depth=%d", n, depth), func(t *testing.T) {
+				seen := make([]bool, n)
+				for i := 0; i < n; i++ {
+					slot := interleaveSlot(i, n, depth)
+					if slot < 0 || slot >= n {
+						t.Fatalf("byte %d: slot %d out of %d", i, slot, n)
+					}
+					if seen[slot] {
+						t.Fatalf("byte %d: slot %d is taken", i, slot)
+					}
+					if depth == 1 && slot != i {
+						t.Fatalf("byte %d: slot %d, depth 1 keeps the order", i, slot)
+					}
+					seen[slot] = true
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package encoder
+
+// Frame layout, in modules (one module = one block of block x block pixels):
+//
+//	+--------------------------------------+
+//	| quiet zone (white)                   |
+//	|  [F]  timing . . . . . . . . .  [F]  |
+//	|  t                                   |
+//	|  .            data cells             |
+//	|  .                                   |
+//	|  [F]                            [F]  |
+//	|                                      |
+//	+--------------------------------------+
+//
+// F - QR style 7x7 finder pattern with a white separator around it.
+// Finder patterns are used by the decoder to find the grid in a scaled,
+// cropped or letterboxed frame, timing patterns to verify the block size.
+const (
+	quietModules  = 2 // white border around the frame
+	finderModules = 7 // 1:1:3:1:1 finder pattern size
+	finderZone    = finderModules + 1
+)
+
+type module uint8
+
+const (
+	moduleWhite module = iota
+	moduleBlack
+	moduleData
+)
+
+type layout struct {
+	block int
+	cols  int
+	rows  int
+	// data cells indexes (row*cols+col) in the write order
+	cells []int
+}
+
+func newLayout(width, height, block int) *layout {
+	l := &layout{
+		block: block,
+		cols:  width / block,
+		rows:  height / block,
+	}
+	// column by column, top to bottom
+	l.cells = make([]int, 0, l.cols*l.rows)
+	for c := 0; c < l.cols; c++ {
+		for r := 0; r < l.rows; r++ {
+			if l.module(c, r) == moduleData {
+				l.cells = append(l.cells, r*l.cols+c)
+			}
+		}
+	}
+	return l
+}
+
+// module returns the fixed color of the module or moduleData for data cells
+func (l *layout) module(c, r int) module {
+	q := quietModules
+	if c < q || r < q || c >= l.cols-q || r >= l.rows-q {
+		return moduleWhite
+	}
+	// coords relative to the nearest corner
+	fc, fr := c-q, r-q
+	if c >= l.cols/2 {
+		fc = l.cols - q - 1 - c
+	}
+	if r >= l.rows/2 {
+		fr = l.rows - q - 1 - r
+	}
+	if fc < finderZone && fr < finderZone {
+		return finderModule(fc, fr)
+	}
+	// timing patterns along the top and the left finders
+	if r == q+finderModules-1 {
+		return timingModule(c - q)
+	}
+	if c == q+finderModules-1 {
+		return timingModule(r - q)
+	}
+	return moduleData
+}
+
+// centers of the finder patterns in module coords
+// order: top left, top right, bottom left, bottom right
+func (l *layout) finders() [4]point {
+	lo := float64(quietModules) + float64(finderModules)/2
+	x := float64(l.cols) - lo
+	y := float64(l.rows) - lo
+	return [4]point{{lo, lo}, {x, lo}, {lo, y}, {x, y}}
+}
+
+func finderModule(c, r int) module {
+	// separator
+	if c == finderModules || r == finderModules {
+		return moduleWhite
+	}
+	d := c
+	if r < d {
+		d = r
+	}
+	if finderModules-1-c < d {
+		d = finderModules - 1 - c
+	}
+	if finderModules-1-r < d {
+		d = finderModules - 1 - r
+	}
+	// d is the distance to the pattern edge: black ring, white ring, black center
+	if d == 1 {
+		return moduleWhite
+	}
+	return moduleBlack
+}
+
+func timingModule(i int) module {
+	if i%2 == 0 {
+		return moduleBlack
+	}
+	return moduleWhite
+}
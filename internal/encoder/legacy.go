@@ -0,0 +1,33 @@
+package encoder
+
+import (
+	"image"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/meta"
+)
+
+// Frames of the reels made before the finder patterns are 4K only,
+// every bit is a 2x2 block from the top left corner, column by column, top to bottom.
+const legacyBlock = 2
+
+// legacyHeader reads the header cells of the older frame format,
+// reports the filename if the frame is of that format
+func legacyHeader(img image.Image) (string, bool) {
+	b := img.Bounds()
+	if b.Dx() != cfg.FrameWidth || b.Dy() != cfg.FrameHeight {
+		return "", false
+	}
+	p := newPlane(img)
+	rows := p.height / legacyBlock
+	header := make([]byte, cfg.SizeMetadata)
+	for idx := 0; idx < len(header)*8; idx++ {
+		x := idx / rows * legacyBlock
+		y := idx % rows * legacyBlock
+		lum := int(p.at(x, y)) + int(p.at(x+1, y)) + int(p.at(x, y+1)) + int(p.at(x+1, y+1))
+		if lum < 4*128 {
+			header[idx>>3] |= 1 << uint(idx&7)
+		}
+	}
+	return meta.ParseLegacy(header)
+}
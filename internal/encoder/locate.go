@@ -0,0 +1,423 @@
+package encoder
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+type point struct {
+	x, y float64
+}
+
+// luminance plane of the frame, 0 - black, 255 - white
+type plane struct {
+	pix    []uint8
+	width  int
+	height int
+}
+
+func newPlane(img image.Image) *plane {
+	b := img.Bounds()
+	p := &plane{
+		pix:    make([]uint8, b.Dx()*b.Dy()),
+		width:  b.Dx(),
+		height: b.Dy(),
+	}
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		// rows of the pixel types png decoder returns, no per pixel type switch
+		var row []uint8
+		switch im := img.(type) {
+		case *image.Gray:
+			copy(p.pix[i:], im.Pix[im.PixOffset(b.Min.X, y):im.PixOffset(b.Max.X, y)])
+			i += b.Dx()
+			continue
+		case *image.NRGBA:
+			row = im.Pix[im.PixOffset(b.Min.X, y):im.PixOffset(b.Max.X, y)]
+		case *image.RGBA:
+			row = im.Pix[im.PixOffset(b.Min.X, y):im.PixOffset(b.Max.X, y)]
+		}
+		if row != nil {
+			for o := 0; o < len(row); o += 4 {
+				p.pix[i] = luma(row[o], row[o+1], row[o+2])
+				i++
+			}
+			continue
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl := rgbAt(img, x, y)
+			p.pix[i] = luma(r, g, bl)
+			i++
+		}
+	}
+	return p
+}
+
+func (p *plane) at(x, y int) uint8 {
+	if x < 0 || y < 0 || x >= p.width || y >= p.height {
+		return 255
+	}
+	return p.pix[y*p.width+x]
+}
+
+// rgbAt returns 8 bit color of the pixel, fast path for the types png decoder returns
+func rgbAt(img image.Image, x, y int) (uint8, uint8, uint8) {
+	switch im := img.(type) {
+	case *image.NRGBA:
+		i := im.PixOffset(x, y)
+		return im.Pix[i], im.Pix[i+1], im.Pix[i+2]
+	case *image.RGBA:
+		i := im.PixOffset(x, y)
+		return im.Pix[i], im.Pix[i+1], im.Pix[i+2]
+	case *image.Gray:
+		v := im.Pix[im.PixOffset(x, y)]
+		return v, v, v
+	}
+	r, g, b, _ := img.At(x, y).RGBA()
+	return uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)
+}
+
+// BT.601
+func luma(r, g, b uint8) uint8 {
+	return uint8((299*int(r) + 587*int(g) + 114*int(b)) / 1000)
+}
+
+// finder pattern candidate
+type finder struct {
+	center point
+	module float64 // estimated module size in pixels
+	count  int     // number of scan lines confirmed the pattern
+}
+
+// locateFinders scans the frame for 1:1:3:1:1 patterns and returns
+// the centers of 4 finder patterns: top left, top right, bottom left, bottom right
+func locateFinders(p *plane, threshold uint8) ([4]finder, error) {
+	var res [4]finder
+	var found []finder
+	for y := 0; y < p.height; y++ {
+		for _, x := range scanRow(p, y, threshold) {
+			f, ok := crossCheck(p, x, y, threshold)
+			if !ok {
+				continue
+			}
+			found = mergeFinder(found, f)
+		}
+	}
+	// random data matches the ratio on a single line quite often,
+	// keep only the candidates that look like the whole pattern
+	verified := found[:0]
+	for _, f := range found {
+		if verifyFinder(p, f, threshold) {
+			verified = append(verified, f)
+		}
+	}
+	found = verified
+	if len(found) < 4 {
+		return res, fmt.Errorf("finder patterns not found (%d of 4)", len(found))
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].count > found[j].count })
+	if len(found) > 8 {
+		found = found[:8]
+	}
+
+	// pick the best 4 forming a quad with similar module sizes
+	best := -1
+	var bestArea float64
+	for a := 0; a < len(found); a++ {
+		for b := a + 1; b < len(found); b++ {
+			for c := b + 1; c < len(found); c++ {
+				for d := c + 1; d < len(found); d++ {
+					q, ok := orderQuad([4]finder{found[a], found[b], found[c], found[d]})
+					if !ok {
+						continue
+					}
+					score := q[0].count + q[1].count + q[2].count + q[3].count
+					area := quadArea(q)
+					if score > best || (score == best && area > bestArea) {
+						best = score
+						bestArea = area
+						res = q
+					}
+				}
+			}
+		}
+	}
+	if best < 0 {
+		return res, fmt.Errorf("finder patterns not found")
+	}
+	return res, nil
+}
+
+// scanRow returns x centers of the 1:1:3:1:1 dark-light-dark-light-dark runs in a row
+func scanRow(p *plane, y int, threshold uint8) []float64 {
+	var res []float64
+	row := p.pix[y*p.width : (y+1)*p.width]
+	// last 5 runs, runs alternate so if the last one is dark the pattern is D-L-D-L-D
+	var runs, starts [5]int
+	n := 0
+	start := 0
+	for x := 1; x <= len(row); x++ {
+		dark := row[start] < threshold
+		if x < len(row) && (row[x] < threshold) == dark {
+			continue
+		}
+		copy(runs[:4], runs[1:])
+		copy(starts[:4], starts[1:])
+		runs[4], starts[4] = x-start, start
+		n++
+		if dark && n >= 5 && isFinderRatio(runs) {
+			res = append(res, float64(starts[2])+float64(runs[2])/2)
+		}
+		start = x
+	}
+	return res
+}
+
+func isFinderRatio(runs [5]int) bool {
+	total := 0
+	for _, r := range runs {
+		if r == 0 {
+			return false
+		}
+		total += r
+	}
+	if total < finderModules {
+		return false
+	}
+	m := float64(total) / finderModules
+	tolerance := m * 0.5
+	return math.Abs(float64(runs[0])-m) < tolerance &&
+		math.Abs(float64(runs[1])-m) < tolerance &&
+		math.Abs(float64(runs[2])-3*m) < 3*tolerance &&
+		math.Abs(float64(runs[3])-m) < tolerance &&
+		math.Abs(float64(runs[4])-m) < tolerance
+}
+
+// crossCheck verifies the pattern vertically and diagonally and refines the center
+func crossCheck(p *plane, cx float64, y int, threshold uint8) (finder, bool) {
+	x := int(cx)
+	cy, vTotal, ok := crossRuns(p, x, y, 0, 1, threshold)
+	if !ok {
+		return finder{}, false
+	}
+	cx2, hTotal, ok := crossRuns(p, x, int(cy), 1, 0, threshold)
+	if !ok {
+		return finder{}, false
+	}
+	// diagonal filters out most of the random data matches
+	if _, _, ok := crossRuns(p, int(cx2), int(cy), 1, 1, threshold); !ok {
+		return finder{}, false
+	}
+	if vTotal > 2*hTotal || hTotal > 2*vTotal {
+		return finder{}, false
+	}
+	return finder{
+		center: point{cx2, cy},
+		module: (vTotal + hTotal) / (2 * finderModules),
+		count:  1,
+	}, true
+}
+
+// crossRuns measures the pattern runs from (x, y) along (dx, dy) in both directions.
+// Returns center coord along the axis (x for horizontal, y otherwise) and total length.
+func crossRuns(p *plane, x, y, dx, dy int, threshold uint8) (float64, float64, bool) {
+	isDark := func(i int) bool {
+		return p.at(x+i*dx, y+i*dy) < threshold
+	}
+	if !isDark(0) {
+		return 0, 0, false
+	}
+	limit := p.width + p.height
+	// center run first, outer runs are bounded by it
+	lo, hi := 0, 1
+	for ; isDark(lo - 1); lo-- {
+		if -lo > limit {
+			return 0, 0, false
+		}
+	}
+	for ; isDark(hi); hi++ {
+		if hi > limit {
+			return 0, 0, false
+		}
+	}
+	var runs [5]int
+	runs[2] = hi - lo
+	i := lo - 1
+	for ; !isDark(i) && runs[1] <= runs[2]; i-- {
+		runs[1]++
+	}
+	for ; isDark(i) && runs[0] <= runs[2]; i-- {
+		runs[0]++
+	}
+	i = hi
+	for ; !isDark(i) && runs[3] <= runs[2]; i++ {
+		runs[3]++
+	}
+	for ; isDark(i) && runs[4] <= runs[2]; i++ {
+		runs[4]++
+	}
+	if !isFinderRatio(runs) {
+		return 0, 0, false
+	}
+	total := float64(runs[0] + runs[1] + runs[2] + runs[3] + runs[4])
+	c := float64(lo+hi) / 2
+	if dx != 0 {
+		return float64(x) + c, total, true
+	}
+	return float64(y) + c, total, true
+}
+
+// verifyFinder samples every module of the 7x7 pattern around the center
+func verifyFinder(p *plane, f finder, threshold uint8) bool {
+	half := finderModules / 2
+	var match int
+	for r := 0; r < finderModules; r++ {
+		for c := 0; c < finderModules; c++ {
+			x := f.center.x + float64(c-half)*f.module
+			y := f.center.y + float64(r-half)*f.module
+			dark := p.at(int(x), int(y)) < threshold
+			if dark == (finderModule(c, r) == moduleBlack) {
+				match++
+			}
+		}
+	}
+	return match >= finderModules*finderModules*9/10
+}
+
+func mergeFinder(found []finder, f finder) []finder {
+	for i := range found {
+		e := &found[i]
+		d := math.Hypot(e.center.x-f.center.x, e.center.y-f.center.y)
+		if d > math.Max(e.module*3, 3) {
+			continue
+		}
+		n := float64(e.count)
+		e.center.x = (e.center.x*n + f.center.x) / (n + 1)
+		e.center.y = (e.center.y*n + f.center.y) / (n + 1)
+		e.module = (e.module*n + f.module) / (n + 1)
+		e.count++
+		return found
+	}
+	return append(found, f)
+}
+
+// orderQuad sorts finders as top left, top right, bottom left, bottom right
+// and checks they form a convex quad with similar module sizes
+func orderQuad(q [4]finder) ([4]finder, bool) {
+	var res [4]finder
+	idx := func(less func(a, b finder) bool) int {
+		best := 0
+		for i := 1; i < 4; i++ {
+			if less(q[i], q[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	tl := idx(func(a, b finder) bool { return a.center.x+a.center.y < b.center.x+b.center.y })
+	br := idx(func(a, b finder) bool { return a.center.x+a.center.y > b.center.x+b.center.y })
+	tr := idx(func(a, b finder) bool { return a.center.x-a.center.y > b.center.x-b.center.y })
+	bl := idx(func(a, b finder) bool { return a.center.x-a.center.y < b.center.x-b.center.y })
+	if tl == br || tl == tr || tl == bl || br == tr || br == bl || tr == bl {
+		return res, false
+	}
+	res = [4]finder{q[tl], q[tr], q[bl], q[br]}
+
+	minM, maxM := res[0].module, res[0].module
+	for _, f := range res {
+		minM = math.Min(minM, f.module)
+		maxM = math.Max(maxM, f.module)
+	}
+	if maxM > minM*1.6 {
+		return res, false
+	}
+	// convex: going around tl, tr, br, bl all turns have the same sign
+	ring := [4]point{res[0].center, res[1].center, res[3].center, res[2].center}
+	sign := 0.0
+	for i := 0; i < 4; i++ {
+		a, b, c := ring[i], ring[(i+1)%4], ring[(i+2)%4]
+		cross := (b.x-a.x)*(c.y-b.y) - (b.y-a.y)*(c.x-b.x)
+		if cross == 0 || (sign != 0 && (cross > 0) != (sign > 0)) {
+			return res, false
+		}
+		sign = cross
+	}
+	return res, true
+}
+
+func quadArea(q [4]finder) float64 {
+	ring := [4]point{q[0].center, q[1].center, q[3].center, q[2].center}
+	var s float64
+	for i := 0; i < 4; i++ {
+		a, b := ring[i], ring[(i+1)%4]
+		s += a.x*b.y - b.x*a.y
+	}
+	return math.Abs(s) / 2
+}
+
+// threshold between the dark and light pixels of the frame
+func (p *plane) threshold() uint8 {
+	var hist [256]int
+	for _, v := range p.pix {
+		hist[v]++
+	}
+	// ignore 1% of the outliers on both ends
+	cut := len(p.pix) / 100
+	lo, hi := 0, 255
+	for n := 0; lo < 255 && n+hist[lo] <= cut; lo++ {
+		n += hist[lo]
+	}
+	for n := 0; hi > 0 && n+hist[hi] <= cut; hi-- {
+		n += hist[hi]
+	}
+	return uint8((lo + hi + 1) / 2)
+}
+
+// homography maps module coords to the image pixels
+type homography [9]float64
+
+func (h *homography) apply(u, v float64) (float64, float64) {
+	w := h[6]*u + h[7]*v + h[8]
+	return (h[0]*u + h[1]*v + h[2]) / w, (h[3]*u + h[4]*v + h[5]) / w
+}
+
+// newHomography solves the projective transform from 4 point pairs
+func newHomography(src, dst [4]point) (homography, error) {
+	var a [8][9]float64
+	for i := 0; i < 4; i++ {
+		u, v := src[i].x, src[i].y
+		x, y := dst[i].x, dst[i].y
+		a[2*i] = [9]float64{u, v, 1, 0, 0, 0, -u * x, -v * x, x}
+		a[2*i+1] = [9]float64{0, 0, 0, u, v, 1, -u * y, -v * y, y}
+	}
+	// gaussian elimination with partial pivoting
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for r := col + 1; r < 8; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return homography{}, fmt.Errorf("degenerate finder patterns")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < 8; r++ {
+			if r == col {
+				continue
+			}
+			k := a[r][col] / a[col][col]
+			for c := col; c < 9; c++ {
+				a[r][c] -= k * a[col][c]
+			}
+		}
+	}
+	var h homography
+	for i := 0; i < 8; i++ {
+		h[i] = a[i][8] / a[i][i]
+	}
+	h[8] = 1
+	return h, nil
+}
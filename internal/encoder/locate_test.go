@@ -0,0 +1,217 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/meta"
+	"github.com/1F47E/go-bitreel/internal/storage"
+)
+
+// small frames keep the tests fast, 8px blocks survive the warps as on a capture
+const (
+	testWidth  = 1280
+	testHeight = 720
+	testBlock  = 8
+)
+
+func testMeta(size int) meta.Metadata {
+	m := meta.New("test.bin")
+	m.SetFrame(1)
+	m.SetTotal(1)
+	m.SetSize(size)
+	return m
+}
+
+func testData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// encodeTest encodes a frame full of random data
+func encodeTest(t testing.TB, enc *FrameEncoder, m meta.Metadata) ([]byte, *image.Gray) {
+	t.Helper()
+	data := testData(enc.Capacity()-cfg.SizeMetadata, 1)
+	m.SetSize(len(data))
+	img, err := enc.EncodeFrame(data, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, img
+}
+
+func saveTest(t testing.TB, img image.Image) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "frame.png")
+	err := storage.SaveImage(filename, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// checkDecoded checks the decoded cells hold the header and the data
+func checkDecoded(t testing.TB, cells []byte, n int, data []byte) {
+	t.Helper()
+	if n != cfg.SizeMetadata+len(data) {
+		t.Fatalf("decoded %d bytes, want %d", n, cfg.SizeMetadata+len(data))
+	}
+	m, err := meta.Parse(cells[:cfg.SizeMetadata])
+	if err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	if !bytes.Equal(cells[cfg.SizeMetadata:n], data) {
+		t.Fatal("data differs")
+	}
+	if ok, _ := m.Validate(data); !ok {
+		t.Fatal("checksum mismatch")
+	}
+}
+
+// warp renders the frame into a w x h capture, at maps a capture pixel to the frame,
+// pixels outside of the frame are bg
+func warp(src *image.Gray, w, h int, bg uint8, at func(x, y float64) (float64, float64)) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := at(float64(x)+0.5, float64(y)+0.5)
+			dst.Pix[y*dst.Stride+x] = bilinear(src, sx-0.5, sy-0.5, bg)
+		}
+	}
+	return dst
+}
+
+func bilinear(src *image.Gray, x, y float64, bg uint8) uint8 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	px := func(xi, yi int) float64 {
+		if xi < 0 || yi < 0 || xi >= src.Rect.Dx() || yi >= src.Rect.Dy() {
+			return float64(bg)
+		}
+		return float64(src.Pix[yi*src.Stride+xi])
+	}
+	xi, yi := int(x0), int(y0)
+	top := px(xi, yi)*(1-fx) + px(xi+1, yi)*fx
+	bottom := px(xi, yi+1)*(1-fx) + px(xi+1, yi+1)*fx
+	return uint8(top*(1-fy) + bottom*fy + 0.5)
+}
+
+// scale maps the capture to the frame stretched to w x h at the offset
+func scale(w, h, offX, offY int) func(x, y float64) (float64, float64) {
+	return func(x, y float64) (float64, float64) {
+		return (x - float64(offX)) * testWidth / float64(w), (y - float64(offY)) * testHeight / float64(h)
+	}
+}
+
+// blur is a box blur of the radius, a defocused camera
+func blur(src *image.Gray, radius int) *image.Gray {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	pass := func(in *image.Gray, dx, dy int) *image.Gray {
+		out := image.NewGray(in.Rect)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				var sum, n int
+				for k := -radius; k <= radius; k++ {
+					xx, yy := x+k*dx, y+k*dy
+					if xx < 0 || yy < 0 || xx >= w || yy >= h {
+						continue
+					}
+					sum += int(in.Pix[yy*in.Stride+xx])
+					n++
+				}
+				out.Pix[y*out.Stride+x] = uint8(sum / n)
+			}
+		}
+		return out
+	}
+	return pass(pass(src, 1, 0), 0, 1)
+}
+
+func TestDecodeWarped(t *testing.T) {
+	enc, err := NewFrameEncoder(testWidth, testHeight, testBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := testMeta(0)
+	m.SetFlag(meta.FlagScrambled)
+	data, frame := encodeTest(t, enc, m)
+
+	// the frame corners on a capture at an angle
+	perspective, err := newHomography(
+		[4]point{{60, 40}, {1220, 20}, {20, 700}, {1260, 690}},
+		[4]point{{0, 0}, {testWidth, 0}, {0, testHeight}, {testWidth, testHeight}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rotated by 4 degrees and scaled down to 80% in the middle of a gray background
+	angle := 4 * math.Pi / 180
+	rotate := func(x, y float64) (float64, float64) {
+		x, y = x-testWidth/2, y-testHeight/2
+		rx := x*math.Cos(angle) + y*math.Sin(angle)
+		ry := -x*math.Sin(angle) + y*math.Cos(angle)
+		return rx/0.8 + testWidth/2, ry/0.8 + testHeight/2
+	}
+
+	tests := []struct {
+		name    string
+		capture *image.Gray
+	}{
+		{"as is", frame},
+		{"downscaled", warp(frame, 960, 540, 255, scale(960, 540, 0, 0))},
+		{"letterboxed", warp(frame, 1280, 960, 0, scale(1280, 720, 0, 120))},
+		{"pillarboxed and downscaled", warp(frame, 1080, 540, 0, scale(960, 540, 60, 0))},
+		{"rotated", warp(frame, testWidth, testHeight, 128, rotate)},
+		{"perspective", warp(frame, testWidth, testHeight, 128, perspective.apply)},
+		{"blurred", blur(frame, 1)},
+		{"downscaled and blurred", blur(warp(frame, 960, 540, 255, scale(960, 540, 0, 0)), 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells, n, _, err := enc.DecodeFrame(saveTest(t, tt.capture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cells == nil {
+				t.Fatal("frame grid is not found")
+			}
+			checkDecoded(t, cells, n, data)
+		})
+	}
+}
+
+func TestSharpestCapture(t *testing.T) {
+	enc, err := NewFrameEncoder(testWidth, testHeight, testBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, frame := encodeTest(t, enc, testMeta(0))
+	downscaled := warp(frame, 960, 540, 255, scale(960, 540, 0, 0))
+
+	// captures of the same frame, the sharp one is in the middle,
+	// a slight blur keeps the full margin so the others are blurred more
+	captures := []*image.Gray{blur(downscaled, 2), downscaled, blur(downscaled, 3)}
+	best, bestSharpness := -1, -1.0
+	for i, capture := range captures {
+		cells, n, sharpness, err := enc.DecodeCapture(saveTest(t, capture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cells == nil {
+			t.Fatalf("capture %d: frame grid is not found", i)
+		}
+		checkDecoded(t, cells, n, data)
+		if sharpness > bestSharpness {
+			best, bestSharpness = i, sharpness
+		}
+	}
+	if best != 1 {
+		t.Fatalf("capture %d is the sharpest, want the not blurred one", best)
+	}
+}
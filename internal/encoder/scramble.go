@@ -0,0 +1,28 @@
+package encoder
+
+// Scrambler whitens the data so long runs of zeros or 0xFF bytes
+// do not turn into flat white or black areas, codecs smooth those aggressively.
+// Keystream is a 32 bit Galois LFSR (x^32 + x^22 + x^2 + x + 1) seeded by the frame number,
+// so every frame has its own pattern and scrambling twice restores the data.
+const lfsrTaps uint32 = 0x80200003
+
+func scramble(data []byte, frame int) []byte {
+	state := uint32(0xACE1ACE1) ^ uint32(frame)*0x9E3779B9
+	if state == 0 {
+		state = 1
+	}
+	res := make([]byte, len(data))
+	for i, b := range data {
+		var k byte
+		for j := 0; j < 8; j++ {
+			bit := state & 1
+			state >>= 1
+			if bit != 0 {
+				state ^= lfsrTaps
+			}
+			k |= byte(bit) << uint(j)
+		}
+		res[i] = b ^ k
+	}
+	return res
+}
@@ -0,0 +1,218 @@
+// Animated GIF writer and reader one frame at a time.
+// image/gif encodes and decodes whole animations in memory,
+// 4K frames do not fit, so frames are encoded as single GIFs and spliced.
+package gifstream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+)
+
+// Palette of the reel frames, index by the symbol: white 0, black 1
+var Palette = color.Palette{
+	color.Gray{255},
+	color.Gray{0},
+}
+
+// Writer writes an endless loop animation
+type Writer struct {
+	f      *os.File
+	w      *bufio.Writer
+	delay  int // 1/100 s
+	frames int
+}
+
+func Create(filename string, delay int) (*Writer, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{f: f, w: bufio.NewWriter(f), delay: delay}, nil
+}
+
+// WriteFrame maps the frame colors to the palette and appends it.
+// All frames must have the size of the first one.
+func (w *Writer) WriteFrame(img image.Image) error {
+	var buf bytes.Buffer
+	err := gif.Encode(&buf, toPaletted(img), &gif.Options{NumColors: len(Palette)})
+	if err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	headerLen, err := headerLength(data)
+	if err != nil {
+		return err
+	}
+	if w.frames == 0 {
+		// header with the global palette and the loop extension
+		_, err = w.w.Write(data[:headerLen])
+		if err != nil {
+			return err
+		}
+		_, err = w.w.Write([]byte{0x21, 0xff, 0x0b, 'N', 'E', 'T', 'S', 'C', 'A', 'P', 'E', '2', '.', '0', 0x03, 0x01, 0x00, 0x00, 0x00})
+		if err != nil {
+			return err
+		}
+	}
+	// graphic control extension with the frame delay
+	gce := []byte{0x21, 0xf9, 0x04, 0x00, 0, 0, 0x00, 0x00}
+	binary.LittleEndian.PutUint16(gce[4:6], uint16(w.delay))
+	_, err = w.w.Write(gce)
+	if err != nil {
+		return err
+	}
+	// image block without the trailer
+	_, err = w.w.Write(data[headerLen : len(data)-1])
+	if err != nil {
+		return err
+	}
+	w.frames++
+	return nil
+}
+
+func (w *Writer) Close() error {
+	_, err := w.w.Write([]byte{0x3b})
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	err = w.w.Flush()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// toPaletted maps black and white, everything else goes to the nearest
+func toPaletted(img image.Image) *image.Paletted {
+	b := img.Bounds()
+	p := image.NewPaletted(b, Palette)
+	m, ok := img.(*image.Gray)
+	if !ok {
+		draw.Draw(p, b, img, b.Min, draw.Src)
+		return p
+	}
+	for y := 0; y < b.Dy(); y++ {
+		row := m.Pix[y*m.Stride : y*m.Stride+b.Dx()]
+		out := p.Pix[y*p.Stride:]
+		for x, v := range row {
+			if v < 128 {
+				out[x] = 1
+			} else {
+				out[x] = 0
+			}
+		}
+	}
+	return p
+}
+
+// headerLength returns the size of the signature, the screen descriptor and the global palette
+func headerLength(data []byte) (int, error) {
+	if len(data) < 13 || string(data[:3]) != "GIF" {
+		return 0, fmt.Errorf("not a GIF")
+	}
+	n := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		n += 3 << ((flags & 0x07) + 1)
+	}
+	if n > len(data) {
+		return 0, fmt.Errorf("broken GIF header")
+	}
+	return n, nil
+}
+
+// ReadFrames calls fn with every frame of the animation, composed on the screen
+// as players show it, so optimized GIFs with partial frames work too
+func ReadFrames(filename string, fn func(img image.Image) error) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	headerLen, err := headerLength(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	header := data[:headerLen]
+	width := int(binary.LittleEndian.Uint16(data[6:8]))
+	height := int(binary.LittleEndian.Uint16(data[8:10]))
+	screen := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(screen, screen.Bounds(), image.White, image.Point{}, draw.Src)
+
+	pos := headerLen
+	var gce []byte
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x3b:
+			return nil
+		case 0x21:
+			end, err := skipSubBlocks(data, pos+2)
+			if err != nil {
+				return err
+			}
+			// graphic control extension belongs to the next image
+			if data[pos+1] == 0xf9 {
+				gce = data[pos:end]
+			}
+			pos = end
+		case 0x2c:
+			if pos+10 > len(data) {
+				return io.ErrUnexpectedEOF
+			}
+			start := pos
+			pos += 10
+			if flags := data[start+9]; flags&0x80 != 0 {
+				pos += 3 << ((flags & 0x07) + 1)
+			}
+			// lzw code size
+			end, err := skipSubBlocks(data, pos+1)
+			if err != nil {
+				return err
+			}
+			pos = end
+
+			// single frame GIF
+			single := make([]byte, 0, len(header)+len(gce)+end-start+1)
+			single = append(single, header...)
+			single = append(single, gce...)
+			single = append(single, data[start:end]...)
+			single = append(single, 0x3b)
+			gce = nil
+			frame, err := gif.Decode(bytes.NewReader(single))
+			if err != nil {
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+			draw.Draw(screen, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+			err = fn(screen)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unknown block 0x%02x", filename, data[pos])
+		}
+	}
+	return nil
+}
+
+// skipSubBlocks returns the position after the sub-blocks starting at pos
+func skipSubBlocks(data []byte, pos int) (int, error) {
+	for {
+		if pos >= len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		n := int(data[pos])
+		pos++
+		if n == 0 {
+			return pos, nil
+		}
+		pos += n
+	}
+}
@@ -0,0 +1,143 @@
+package gifstream
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testFrame(width, height int, seed int64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(seed))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(2)) * 255
+	}
+	return img
+}
+
+func readAll(t *testing.T, filename string) []*image.Gray {
+	t.Helper()
+	var frames []*image.Gray
+	err := ReadFrames(filename, func(img image.Image) error {
+		// the screen is reused for the next frame
+		frames = append(frames, image.NewGray(img.Bounds()))
+		copy(frames[len(frames)-1].Pix, img.(*image.Gray).Pix)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+func TestRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "out.gif")
+	w, err := Create(filename, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frames []*image.Gray
+	for i := 0; i < 3; i++ {
+		frame := testFrame(64, 48, int64(i))
+		// gray levels go to the nearest palette color
+		frame.Pix[0], frame.Pix[1] = 100, 200
+		frames = append(frames, frame)
+		err = w.WriteFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame.Pix[0], frame.Pix[1] = 0, 255
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, filename)
+	if len(got) != len(frames) {
+		t.Fatalf("%d frames, want %d", len(got), len(frames))
+	}
+	for i := range frames {
+		if !bytes.Equal(got[i].Pix, frames[i].Pix) {
+			t.Fatalf("frame %d differs", i)
+		}
+	}
+
+	// a valid animation for the standard decoder
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != len(frames) || anim.LoopCount != 0 {
+		t.Fatalf("%d frames, loop count %d, want %d frames looped forever", len(anim.Image), anim.LoopCount, len(frames))
+	}
+	for i, d := range anim.Delay {
+		if d != 10 {
+			t.Fatalf("frame %d delay %d, want 10", i, d)
+		}
+	}
+}
+
+// TestReadPartialFrames reads an optimized GIF, the second frame updates a part of the screen
+func TestReadPartialFrames(t *testing.T) {
+	palette := color.Palette{color.Gray{255}, color.Gray{0}}
+	full := image.NewPaletted(image.Rect(0, 0, 16, 16), palette)
+	part := image.NewPaletted(image.Rect(4, 4, 8, 8), palette)
+	for i := range part.Pix {
+		part.Pix[i] = 1
+	}
+	filename := filepath.Join(t.TempDir(), "optimized.gif")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gif.EncodeAll(f, &gif.GIF{Image: []*image.Paletted{full, part}, Delay: []int{10, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := readAll(t, filename)
+	if len(frames) != 2 {
+		t.Fatalf("%d frames, want 2", len(frames))
+	}
+	if frames[1].Bounds() != full.Bounds() {
+		t.Fatalf("frame bounds %v, want the screen %v", frames[1].Bounds(), full.Bounds())
+	}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			want := uint8(255)
+			if image.Pt(x, y).In(part.Rect) {
+				want = 0
+			}
+			if got := frames[1].GrayAt(x, y).Y; got != want {
+				t.Fatalf("pixel %d,%d is %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestReadNotGIF(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "frame.gif")
+	err := os.WriteFile(filename, []byte("not a gif at all"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ReadFrames(filename, func(image.Image) error { return nil })
+	if err == nil {
+		t.Fatal("no error for a file that is not a GIF")
+	}
+}
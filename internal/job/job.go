@@ -14,14 +14,27 @@ type JobDec struct {
 
 // res from the decoding worker
 type JobDecRes struct {
+	// index of the frame file, results come out of order
+	Idx  int
 	Data []byte
 	Meta meta.Metadata
+	// data bytes of a full frame, the frame capacity without the header
+	FrameSize int
+	// checksum matches the data
+	Valid bool
+	// frame is in the output already, not decoded
+	Skipped bool
+	// pixel errors corrected by the decoder
+	Corrected int
+	// capture sharpness 0-1, optical decoding only
+	Sharpness float64
 }
 
 // job for the encoding worker
 type JobEnc struct {
 	Buffer   []byte
 	Metadata meta.Metadata
+	// frame file number in the volume, the header has the number in the set
 	FrameNum int
 }
 
@@ -39,4 +52,6 @@ func (j *JobEnc) Print() string {
 func (j *JobEnc) Update(buf []byte, bufLen int, frameNum int) {
 	j.Buffer = append([]byte{}, buf[:bufLen]...)
 	j.FrameNum = frameNum
+	j.Metadata.SetFrame(j.Metadata.FirstFrame() + frameNum - 1)
+	j.Metadata.SetSize(bufLen)
 }
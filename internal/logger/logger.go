@@ -1,7 +1,10 @@
 package logger
 
 import (
+	"io"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
@@ -23,3 +26,78 @@ func newLogger() *logrus.Logger {
 
 	return log
 }
+
+// SetPlain turns off the colors, for log files and pipes, json writes an entry per line as json
+func SetPlain(json bool) {
+	if json {
+		Log.SetFormatter(&logrus.JSONFormatter{})
+		return
+	}
+	Log.SetFormatter(&logrus.TextFormatter{
+		DisableColors: true,
+		FullTimestamp: true,
+		// reports like the reel info are multiline
+		DisableQuote: true,
+	})
+}
+
+// Divert hands the warnings and the errors to warn while the TUI view is on the screen,
+// info entries are kept and written on restore, debug entries are dropped.
+// Returns the function to restore the output.
+func Divert(warn func(msg string)) func() {
+	out := Log.Out
+	h := &divertHook{warn: warn, out: out}
+	hooks := Log.ReplaceHooks(logrus.LevelHooks{})
+	Log.AddHook(h)
+	Log.SetOutput(io.Discard)
+	return func() {
+		Log.ReplaceHooks(hooks)
+		Log.SetOutput(out)
+		h.flush()
+	}
+}
+
+type divertHook struct {
+	mu   sync.Mutex
+	warn func(msg string)
+	out  io.Writer
+	kept [][]byte
+}
+
+func (h *divertHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *divertHook) Fire(e *logrus.Entry) error {
+	switch {
+	case e.Level <= logrus.FatalLevel:
+		// exits right after, the view is gone anyway
+		data, err := e.Logger.Formatter.Format(e)
+		if err != nil {
+			return err
+		}
+		_, err = h.out.Write(data)
+		return err
+	case e.Level <= logrus.WarnLevel:
+		h.warn(strings.TrimSpace(e.Message))
+	case e.Level == logrus.InfoLevel:
+		// entries are reused, format it now
+		data, err := e.Logger.Formatter.Format(e)
+		if err != nil {
+			return err
+		}
+		h.mu.Lock()
+		h.kept = append(h.kept, data)
+		h.mu.Unlock()
+	}
+	return nil
+}
+
+func (h *divertHook) flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, data := range h.kept {
+		_, _ = h.out.Write(data)
+	}
+	h.kept = nil
+}
@@ -0,0 +1,64 @@
+package meta
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+)
+
+// Manifest is a copy of the reel header and the frames index stored outside of the frames,
+// so a reel with damaged first frames can still be identified and verified.
+type Manifest struct {
+	// header of the reel, frame number is not set
+	Meta Metadata
+	// data checksum of every frame of the volume, index is the frame number - 1 in the volume
+	Checksums []uint64
+}
+
+// MarshalBinary layout: header, frames count uint32, checksums uint64 each
+func (m *Manifest) MarshalBinary() ([]byte, error) {
+	md := m.Meta
+	md.SetFrame(0)
+	buf := make([]byte, 0, cfg.SizeMetadata+4+8*len(m.Checksums))
+	buf = append(buf, md.header(0)...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.Checksums)))
+	for _, c := range m.Checksums {
+		buf = binary.BigEndian.AppendUint64(buf, c)
+	}
+	return buf, nil
+}
+
+func (m *Manifest) UnmarshalBinary(data []byte) error {
+	if len(data) < cfg.SizeMetadata+4 {
+		return fmt.Errorf("manifest is too short: %d", len(data))
+	}
+	md, err := Parse(data[:cfg.SizeMetadata])
+	if err != nil {
+		return fmt.Errorf("manifest header: %w", err)
+	}
+	data = data[cfg.SizeMetadata:]
+	count := int(binary.BigEndian.Uint32(data[:4]))
+	data = data[4:]
+	if len(data) != count*8 {
+		return fmt.Errorf("manifest index size mismatch: %d checksums, %d bytes", count, len(data))
+	}
+	m.Meta = md
+	m.Checksums = make([]uint64, count)
+	for i := range m.Checksums {
+		m.Checksums[i] = binary.BigEndian.Uint64(data[i*8:])
+	}
+	return nil
+}
+
+// Frame returns the metadata of the frame number in the volume as it was in the frame header,
+// except the data size. False if the frame is not in the index.
+func (m *Manifest) Frame(frame int) (Metadata, bool) {
+	if frame < 1 || frame > len(m.Checksums) {
+		return Metadata{}, false
+	}
+	md := m.Meta
+	md.SetFrame(md.FirstFrame() + frame - 1)
+	md.checksum = m.Checksums[frame-1]
+	return md, true
+}
@@ -2,6 +2,7 @@ package meta
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"path/filepath"
@@ -12,16 +13,66 @@ import (
 	"github.com/1F47E/go-bitreel/internal/logger"
 )
 
+// Header layout
+//
+//	0:8    checksum
+//	8:16   timestamp
+//	16     version
+//	17     flags
+//	18:22  frame sequence number, starts from 1
+//	22:26  total frames count
+//	26:30  data size in the frame
+//	30:32  interleaver depth, 0 - off
+//	32:40  set ID of the volumes, 0 - single reel
+//	40:42  volume index, starts from 1
+//	42:44  volumes count
+//	44:48  frames per volume, the last volume may have less
+//	48:64  reserved
+//	64:    filename with EOF marker
+//
+// Frame numbers and the total count are for the whole set,
+// so frames from all the volumes can be merged in any order.
+const (
+	offsetVersion      = 16
+	offsetFlags        = 17
+	offsetFrame        = 18
+	offsetTotal        = 22
+	offsetSize         = 26
+	offsetDepth        = 30
+	offsetSet          = 32
+	offsetVolume       = 40
+	offsetVolumes      = 42
+	offsetVolumeFrames = 44
+	offsetFilename     = 64
+)
+
+// Header flags, settings the frame was encoded with
+const (
+	// data is XORed with the frame keystream
+	FlagScrambled uint8 = 1 << iota
+)
+
 type Metadata struct {
-	Filename  string
-	timestamp int64
-	checksum  uint64
+	Filename     string
+	timestamp    int64
+	checksum     uint64
+	version      uint8
+	flags        uint8
+	frame        uint32
+	total        uint32
+	size         uint32
+	depth        uint16
+	set          uint64
+	volume       uint16
+	volumes      uint16
+	volumeFrames uint32
 }
 
 func New(path string) Metadata {
 	return Metadata{
 		Filename:  encodeFilename(path),
 		timestamp: time.Now().Unix(),
+		version:   cfg.MetadataVersion,
 	}
 }
 
@@ -32,24 +83,71 @@ func Parse(header []byte) (Metadata, error) {
 	log.Debug("Header len: ", len(header))
 	log.Debugf("Header: %v\n", header)
 
+	if len(header) < cfg.SizeMetadata {
+		return Metadata{}, fmt.Errorf("header is too short: %d", len(header))
+	}
 	checksumBytes := header[:8]
 	timestampBytes := header[8:16]
 	timestamp := int64(binary.BigEndian.Uint64(timestampBytes))
 
-	filenameBytes := header[16:]
-	// find end of the filename by marker
-	end := strings.Index(string(filenameBytes), cfg.MetadataEOFMarker)
-	filename := string(filenameBytes[:end])
-
 	checksum := binary.BigEndian.Uint64(checksumBytes)
 	m := Metadata{
-		Filename:  filename,
-		timestamp: timestamp,
-		checksum:  checksum,
+		timestamp:    timestamp,
+		checksum:     checksum,
+		version:      header[offsetVersion],
+		flags:        header[offsetFlags],
+		frame:        binary.BigEndian.Uint32(header[offsetFrame : offsetFrame+4]),
+		total:        binary.BigEndian.Uint32(header[offsetTotal : offsetTotal+4]),
+		size:         binary.BigEndian.Uint32(header[offsetSize : offsetSize+4]),
+		depth:        binary.BigEndian.Uint16(header[offsetDepth : offsetDepth+2]),
+		set:          binary.BigEndian.Uint64(header[offsetSet : offsetSet+8]),
+		volume:       binary.BigEndian.Uint16(header[offsetVolume : offsetVolume+2]),
+		volumes:      binary.BigEndian.Uint16(header[offsetVolumes : offsetVolumes+2]),
+		volumeFrames: binary.BigEndian.Uint32(header[offsetVolumeFrames : offsetVolumeFrames+4]),
+	}
+	if m.version != cfg.MetadataVersion {
+		return m, fmt.Errorf("unsupported metadata version %d", m.version)
+	}
+
+	filenameBytes := header[offsetFilename:cfg.SizeMetadata]
+	// find end of the filename by marker
+	end := strings.Index(string(filenameBytes), cfg.MetadataEOFMarker)
+	if end < 0 {
+		return m, fmt.Errorf("filename EOF marker not found")
 	}
+	m.Filename = string(filenameBytes[:end])
 	return m, nil
 }
 
+// ErrLegacy is the error for the frames of a reel made before the finder patterns,
+// the grid is fixed and the header has no version and no frame numbers
+var ErrLegacy = errors.New("reel made by an older bitreel, decode it with the release it was made with")
+
+// ParseLegacy reads the filename from the header of an older bitreel:
+// checksum, timestamp and the filename with EOF marker right after them.
+// Reports false if the header is not of that format.
+func ParseLegacy(header []byte) (string, bool) {
+	if len(header) < cfg.SizeMetadata {
+		return "", false
+	}
+	timestamp := int64(binary.BigEndian.Uint64(header[8:16]))
+	if timestamp <= 0 || timestamp > time.Now().Add(24*time.Hour).Unix() {
+		return "", false
+	}
+	filenameBytes := header[16:cfg.SizeMetadata]
+	end := strings.Index(string(filenameBytes), cfg.MetadataEOFMarker)
+	if end <= 0 {
+		return "", false
+	}
+	// the version byte of the current header is not a filename character
+	for _, b := range filenameBytes[:end] {
+		if b < 0x20 || b == 0x7f {
+			return "", false
+		}
+	}
+	return string(filenameBytes[:end]), true
+}
+
 func (m *Metadata) IsOk() bool {
 	if len(m.Filename) > 0 && m.timestamp > 0 {
 		return true
@@ -67,10 +165,107 @@ func (m *Metadata) FormatDatetime() string {
 	return localTime.Format(time.RFC822)
 }
 
+// Timestamp returns the encoding time, unix seconds
+func (m *Metadata) Timestamp() int64 {
+	return m.timestamp
+}
+
+// SetTimestamp keeps the encoding time of an interrupted encode on resume
+func (m *Metadata) SetTimestamp(ts int64) {
+	m.timestamp = ts
+}
+
 func (m *Metadata) Checksum() uint64 {
 	return m.checksum
 }
 
+func (m *Metadata) HasFlag(flag uint8) bool {
+	return m.flags&flag != 0
+}
+
+func (m *Metadata) SetFlag(flag uint8) {
+	m.flags |= flag
+}
+
+// Frame returns the frame sequence number, 0 if not set
+func (m *Metadata) Frame() int {
+	return int(m.frame)
+}
+
+func (m *Metadata) SetFrame(frame int) {
+	m.frame = uint32(frame)
+}
+
+// Total returns the total frames count of the reel
+func (m *Metadata) Total() int {
+	return int(m.total)
+}
+
+func (m *Metadata) SetTotal(total int) {
+	m.total = uint32(total)
+}
+
+// Size returns the data size in the frame
+func (m *Metadata) Size() int {
+	return int(m.size)
+}
+
+func (m *Metadata) SetSize(size int) {
+	m.size = uint32(size)
+}
+
+// Interleave returns the interleaver depth, 0 if the data is not interleaved
+func (m *Metadata) Interleave() int {
+	return int(m.depth)
+}
+
+func (m *Metadata) SetInterleave(depth int) {
+	m.depth = uint16(depth)
+}
+
+// Set returns the set ID shared by all the volumes, 0 for a single reel
+func (m *Metadata) Set() uint64 {
+	return m.set
+}
+
+// Volume returns the volume index starting from 1, 0 for a single reel
+func (m *Metadata) Volume() int {
+	return int(m.volume)
+}
+
+// Volumes returns the volumes count of the set, 0 for a single reel
+func (m *Metadata) Volumes() int {
+	return int(m.volumes)
+}
+
+// VolumeFrames returns the frames count of a full volume
+func (m *Metadata) VolumeFrames() int {
+	return int(m.volumeFrames)
+}
+
+func (m *Metadata) SetVolume(set uint64, volume, volumes, volumeFrames int) {
+	m.set = set
+	m.volume = uint16(volume)
+	m.volumes = uint16(volumes)
+	m.volumeFrames = uint32(volumeFrames)
+}
+
+// FirstFrame returns the sequence number of the first frame of the volume
+func (m *Metadata) FirstFrame() int {
+	if m.volume == 0 {
+		return 1
+	}
+	return (m.Volume()-1)*m.VolumeFrames() + 1
+}
+
+// VolumeOf returns the volume index of the frame sequence number, 0 for a single reel
+func (m *Metadata) VolumeOf(frame int) int {
+	if m.volumeFrames == 0 {
+		return 0
+	}
+	return (frame-1)/m.VolumeFrames() + 1
+}
+
 // validate
 func (m *Metadata) Validate(buff []byte) (bool, error) {
 	checksum, err := generateChecksum(&buff)
@@ -80,14 +275,19 @@ func (m *Metadata) Validate(buff []byte) (bool, error) {
 	return checksum == m.checksum, nil
 }
 
-func (m *Metadata) Hash(bytes []byte) ([]bool, error) {
-	log := logger.Log.WithField("scope", "meta hasher")
-
-	header := make([]byte, cfg.SizeMetadata)
+// Hash returns the header with the checksum of the data
+func (m *Metadata) Hash(bytes []byte) ([]byte, error) {
 	checksum, err := generateChecksum(&bytes)
 	if err != nil {
 		return nil, err
 	}
+	return m.header(checksum), nil
+}
+
+// header serializes the metadata with the data checksum
+func (m *Metadata) header(checksum uint64) []byte {
+	log := logger.Log.WithField("scope", "meta hasher")
+	header := make([]byte, cfg.SizeMetadata)
 
 	checksumBytes := convertUint64ToBytes(checksum)
 
@@ -105,15 +305,30 @@ func (m *Metadata) Hash(bytes []byte) ([]bool, error) {
 	l = s + 8
 	copy(header[s:l], tsBytes[:])
 
-	// copy filename
-	fnBytes := make([]byte, len(m.Filename))
-	copy(fnBytes, []byte(m.Filename))
+	// version and frame number
+	header[offsetVersion] = m.version
+	header[offsetFlags] = m.flags
+	binary.BigEndian.PutUint32(header[offsetFrame:offsetFrame+4], m.frame)
+	binary.BigEndian.PutUint32(header[offsetTotal:offsetTotal+4], m.total)
+	binary.BigEndian.PutUint32(header[offsetSize:offsetSize+4], m.size)
+	binary.BigEndian.PutUint16(header[offsetDepth:offsetDepth+2], m.depth)
+	binary.BigEndian.PutUint64(header[offsetSet:offsetSet+8], m.set)
+	binary.BigEndian.PutUint16(header[offsetVolume:offsetVolume+2], m.volume)
+	binary.BigEndian.PutUint16(header[offsetVolumes:offsetVolumes+2], m.volumes)
+	binary.BigEndian.PutUint32(header[offsetVolumeFrames:offsetVolumeFrames+4], m.volumeFrames)
+
+	// copy filename, parsed metadata has no EOF marker
+	filename := m.Filename
+	if !strings.HasSuffix(filename, cfg.MetadataEOFMarker) {
+		filename += cfg.MetadataEOFMarker
+	}
+	fnBytes := []byte(filename)
 	log.Debugf("META:Filename bytes: %v\n", fnBytes)
-	s = l
-	l = s + len(m.Filename)
+	s = offsetFilename
+	l = s + len(fnBytes)
 	copy(header[s:l], fnBytes[:])
 
-	return bytesToBits(header), nil
+	return header
 }
 
 // get datetime in users format
@@ -123,6 +338,12 @@ func (m *Metadata) GetDatetime() string {
 	return localTime.Format(time.RFC822)
 }
 
+// Sum returns the data checksum as stored in the frame header
+func Sum(data []byte) uint64 {
+	checksum, _ := generateChecksum(&data)
+	return checksum
+}
+
 func generateChecksum(bytes *[]byte) (uint64, error) {
 	hasher := fnv.New64a()
 	_, err := hasher.Write(*bytes)
@@ -138,24 +359,21 @@ func convertUint64ToBytes(num uint64) []byte {
 	return byteArray
 }
 
+// encodeFilename returns the basename with the EOF marker, long names are cut to fit the header
 func encodeFilename(path string) string {
 	filename := path[strings.LastIndex(path, "/")+1:]
-	if len(filename) > cfg.MetadataMaxFilenameLen {
+	// the marker takes a byte of the space left in the header
+	if len(filename)+len(cfg.MetadataEOFMarker) > cfg.MetadataMaxFilenameLen {
 		ext := filepath.Ext(filename) // with a dot
 		maxLen := cfg.MetadataMaxFilenameLen - len(ext) - len(cfg.MetadataFilenameCutDelimeter) - len(cfg.MetadataEOFMarker)
+		if maxLen < 1 {
+			// the extension does not fit, it is cut with the name
+			ext = ""
+			maxLen = cfg.MetadataMaxFilenameLen - len(cfg.MetadataFilenameCutDelimeter) - len(cfg.MetadataEOFMarker)
+		}
 		filename = filename[:maxLen] + cfg.MetadataFilenameCutDelimeter + ext
 	}
 	// add marker to the end of the filename so on decoding we know the end
 	filename += cfg.MetadataEOFMarker
 	return filename
 }
-
-func bytesToBits(bytes []byte) []bool {
-	bits := make([]bool, 8*len(bytes))
-	for i, b := range bytes {
-		for j := 0; j < 8; j++ {
-			bits[i*8+j] = (b & (1 << uint(j))) != 0
-		}
-	}
-	return bits
-}
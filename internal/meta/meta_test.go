@@ -0,0 +1,52 @@
+package meta
+
+import (
+	"strings"
+	"testing"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+)
+
+func TestFilenameFitsHeader(t *testing.T) {
+	long := cfg.MetadataMaxFilenameLen
+	tests := []struct {
+		name     string
+		filename string
+		cut      bool
+	}{
+		{"short", "backup.tar", false},
+		{"one below the limit", strings.Repeat("a", long-5) + ".tar", false},
+		{"at the limit", strings.Repeat("a", long-4) + ".tar", true},
+		{"above the limit", strings.Repeat("a", long+100) + ".tar", true},
+		{"long extension", "a." + strings.Repeat("b", long+10), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New("/some/dir/" + tt.filename)
+			m.SetTimestamp(1)
+			header, err := m.Hash([]byte("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(header) != cfg.SizeMetadata {
+				t.Fatalf("header is %d bytes, want %d", len(header), cfg.SizeMetadata)
+			}
+			parsed, err := Parse(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.cut {
+				if parsed.Filename != tt.filename {
+					t.Fatalf("filename %q, want %q", parsed.Filename, tt.filename)
+				}
+				return
+			}
+			if !strings.Contains(parsed.Filename, cfg.MetadataFilenameCutDelimeter) {
+				t.Fatalf("filename %q is not cut", parsed.Filename)
+			}
+			if len(parsed.Filename)+len(cfg.MetadataEOFMarker) > cfg.MetadataMaxFilenameLen {
+				t.Fatalf("filename is %d bytes, limit %d", len(parsed.Filename), cfg.MetadataMaxFilenameLen)
+			}
+		})
+	}
+}
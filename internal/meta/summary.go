@@ -0,0 +1,104 @@
+package meta
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+)
+
+// Summary of the reel stored in the container metadata and the sidecar file,
+// readable by the usual media tools without decoding the frames
+type Summary struct {
+	Version   int    `json:"version"`
+	Filename  string `json:"filename"`
+	Timestamp int64  `json:"timestamp"`
+	// original file size in bytes, 0 if unknown
+	Size int64 `json:"size,omitempty"`
+	// sha256 of the original file, hex
+	SHA256    string   `json:"sha256,omitempty"`
+	Frames    int      `json:"frames"`
+	FrameSize int      `json:"frame_size,omitempty"` // data bytes per frame
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	Settings  Settings `json:"settings"`
+	// volumes of a split reel, empty for a single reel
+	Set          string `json:"set,omitempty"` // hex
+	Volume       int    `json:"volume,omitempty"`
+	Volumes      int    `json:"volumes,omitempty"`
+	VolumeFrames int    `json:"volume_frames,omitempty"`
+}
+
+type Settings struct {
+	Block      int  `json:"block,omitempty"`
+	Scramble   bool `json:"scramble"`
+	Interleave int  `json:"interleave"`
+	Audio      bool `json:"audio"`
+}
+
+// NewSummary fills the fields known from the header,
+// the rest is up to the caller
+func NewSummary(m Metadata) *Summary {
+	s := &Summary{
+		Version:   int(m.version),
+		Filename:  strings.TrimSuffix(m.Filename, cfg.MetadataEOFMarker),
+		Timestamp: m.timestamp,
+		Frames:    m.Total(),
+		Width:     cfg.FrameWidth,
+		Height:    cfg.FrameHeight,
+		Settings: Settings{
+			Scramble:   m.HasFlag(FlagScrambled),
+			Interleave: m.Interleave(),
+		},
+	}
+	if m.Volumes() > 0 {
+		s.Set = fmt.Sprintf("%016x", m.Set())
+		s.Volume = m.Volume()
+		s.Volumes = m.Volumes()
+		s.VolumeFrames = m.VolumeFrames()
+	}
+	return s
+}
+
+func ParseSummary(data []byte) (*Summary, error) {
+	var s Summary
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+	if s.Version == 0 || s.Filename == "" {
+		return nil, fmt.Errorf("not a bitreel summary")
+	}
+	return &s, nil
+}
+
+func (s *Summary) JSON() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func (s *Summary) Print() string {
+	lines := []string{
+		fmt.Sprintf("Filename: %s", s.Filename),
+		fmt.Sprintf("Encoded: %s", time.Unix(s.Timestamp, 0).Local().Format(time.RFC822)),
+		fmt.Sprintf("Frames: %d (%dx%d)", s.Frames, s.Width, s.Height),
+	}
+	if s.Volumes > 0 {
+		lines = append(lines, fmt.Sprintf("Volume: %d of %d, set %s", s.Volume, s.Volumes, s.Set))
+	}
+	if s.Size > 0 {
+		lines = append(lines, fmt.Sprintf("Size: %d bytes", s.Size))
+	}
+	if s.SHA256 != "" {
+		lines = append(lines, fmt.Sprintf("SHA256: %s", s.SHA256))
+	}
+	st := s.Settings
+	settings := fmt.Sprintf("Settings: scramble %v, interleave %d, audio %v", st.Scramble, st.Interleave, st.Audio)
+	if st.Block > 0 {
+		settings += fmt.Sprintf(", block %d", st.Block)
+	}
+	lines = append(lines, settings)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,194 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	cfg "github.com/1F47E/go-bitreel/internal/config"
+	"github.com/1F47E/go-bitreel/internal/encoder"
+	"github.com/1F47E/go-bitreel/internal/meta"
+)
+
+// image placement on the page, see AddPage
+var reImageMatrix = regexp.MustCompile(`q ([\d.]+) 0 0 ([\d.]+) ([\d.]+) ([\d.]+) cm /Im Do Q`)
+
+// scan renders the page image at the dpi as a scanner does, rotated by the angle in degrees
+// around the page center, the paper is white. Text lines are not rendered.
+func scan(t *testing.T, pdfData []byte, img *image.Gray, dpi, angle float64) *image.Gray {
+	t.Helper()
+	m := reImageMatrix.FindSubmatch(pdfData)
+	if m == nil {
+		t.Fatal("image placement is not found")
+	}
+	var v [4]float64
+	for i := range v {
+		v[i], _ = strconv.ParseFloat(string(m[i+1]), 64)
+	}
+	imgW, imgH, x0, y0 := v[0], v[1], v[2], v[3]
+
+	px := dpi / 72
+	w, h := int(pageWidth*px), int(pageHeight*px)
+	cx, cy := float64(w)/2, float64(h)/2
+	sin, cos := math.Sin(angle*math.Pi/180), math.Cos(angle*math.Pi/180)
+	b := img.Bounds()
+	res := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// scan pixel to the page point, pdf y goes up
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			sx, sy := dx*cos+dy*sin+cx, -dx*sin+dy*cos+cy
+			pageX, pageY := sx/px, pageHeight-sy/px
+			// page point to the image pixel
+			u := (pageX - x0) / imgW * float64(b.Dx())
+			v := (y0 + imgH - pageY) / imgH * float64(b.Dy())
+			res.Pix[y*res.Stride+x] = bilinear(img, u-0.5, v-0.5)
+		}
+	}
+	return res
+}
+
+// bilinear samples the image, white outside of it
+func bilinear(img *image.Gray, x, y float64) uint8 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	at := func(xi, yi int) float64 {
+		if xi < 0 || yi < 0 || xi >= img.Rect.Dx() || yi >= img.Rect.Dy() {
+			return 255
+		}
+		return float64(img.Pix[yi*img.Stride+xi])
+	}
+	xi, yi := int(x0), int(y0)
+	top := at(xi, yi)*(1-fx) + at(xi+1, yi)*fx
+	bottom := at(xi, yi+1)*(1-fx) + at(xi+1, yi+1)*fx
+	return uint8(top*(1-fy) + bottom*fy + 0.5)
+}
+
+// scannedPDF writes the scan as a jpeg image, as scanners do
+func scannedPDF(t *testing.T, img *image.Gray) string {
+	t.Helper()
+	var jpg bytes.Buffer
+	err := jpeg.Encode(&jpg, img, &jpeg.Options{Quality: 85})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bytes.Buffer
+	fmt.Fprintf(&doc, "%%PDF-1.4\n1 0 obj\n<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n",
+		img.Rect.Dx(), img.Rect.Dy(), jpg.Len())
+	doc.Write(jpg.Bytes())
+	doc.WriteString("\nendstream\nendobj\n%%EOF\n")
+	filename := filepath.Join(t.TempDir(), "scan.pdf")
+	err = os.WriteFile(filename, doc.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestPaperRoundTrip(t *testing.T) {
+	enc, err := encoder.NewFrameEncoder(cfg.PageWidth, cfg.PageHeight, cfg.PageBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, enc.Capacity()-cfg.SizeMetadata)
+	rand.New(rand.NewSource(1)).Read(data)
+	md := meta.New("paper.bin")
+	md.SetFrame(1)
+	md.SetTotal(1)
+	md.SetSize(len(data))
+	md.SetFlag(meta.FlagScrambled)
+	frame, err := enc.EncodeFrame(data, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "paper.pdf")
+	w, err := Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.AddPage(frame, "bitreel | paper.bin", "page 1 of 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close("paper.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the page image is stored lossless
+	images, err := Images(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("%d images, want 1", len(images))
+	}
+	page, ok := images[0].(*image.Gray)
+	if !ok || !bytes.Equal(page.Pix, frame.Pix) {
+		t.Fatal("page image differs from the frame")
+	}
+
+	pdfData, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		dpi   float64
+		angle float64
+	}{
+		{"150 dpi", 150, 0},
+		{"100 dpi", 100, 0},
+		{"rotated", 150, 2},
+		{"rotated the other way and scaled", 120, -3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the scanner saves the page as a jpeg in a pdf
+			scanned := scannedPDF(t, scan(t, pdfData, page, tt.dpi, tt.angle))
+			images, err := Images(scanned)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := filepath.Join(t.TempDir(), "scan.png")
+			f, err := os.Create(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = png.Encode(f, images[0])
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cells, n, _, err := enc.DecodeCapture(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cells == nil || n != cfg.SizeMetadata+len(data) {
+				t.Fatalf("decoded %d bytes, want %d", n, cfg.SizeMetadata+len(data))
+			}
+			m, err := meta.Parse(cells[:cfg.SizeMetadata])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cells[cfg.SizeMetadata:n], data) {
+				t.Fatal("data differs")
+			}
+			if ok, _ := m.Validate(data); !ok {
+				t.Fatal("checksum mismatch")
+			}
+		})
+	}
+}
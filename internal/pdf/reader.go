@@ -0,0 +1,134 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+var (
+	reObject = regexp.MustCompile(`\d+\s+\d+\s+obj\b`)
+	reImage  = regexp.MustCompile(`/Subtype\s*/Image`)
+	reWidth  = regexp.MustCompile(`/Width\s+(\d+)`)
+	reHeight = regexp.MustCompile(`/Height\s+(\d+)`)
+	reBits   = regexp.MustCompile(`/BitsPerComponent\s+(\d+)`)
+	// direct length only, not a reference
+	reLength = regexp.MustCompile(`/Length\s+(\d+)\s*[/>]`)
+)
+
+// Images extracts the images in the document order, one per page for the paper output.
+// Supports what scanners produce: 8 bit gray or RGB with the Flate filter and JPEG.
+// Compressed object streams and encryption are not supported.
+func Images(filename string) ([]image.Image, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil, fmt.Errorf("%s is not a PDF file", filename)
+	}
+
+	var images []image.Image
+	locs := reObject.FindAllIndex(data, -1)
+	for i, loc := range locs {
+		end := len(data)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		obj := data[loc[1]:end]
+		dictEnd := bytes.Index(obj, []byte("stream"))
+		if dictEnd < 0 {
+			continue
+		}
+		dict := obj[:dictEnd]
+		if !reImage.Match(dict) {
+			continue
+		}
+		stream := obj[dictEnd+len("stream"):]
+		// stream keyword is followed by CRLF or LF
+		stream = bytes.TrimPrefix(stream, []byte("\r"))
+		stream = bytes.TrimPrefix(stream, []byte("\n"))
+		if n := intValue(reLength, dict); n > 0 && n <= len(stream) {
+			stream = stream[:n]
+		} else {
+			streamEnd := bytes.LastIndex(stream, []byte("endstream"))
+			if streamEnd < 0 {
+				return nil, fmt.Errorf("broken image stream in %s", filename)
+			}
+			// data is followed by one EOL
+			stream = stream[:streamEnd]
+			stream = bytes.TrimSuffix(stream, []byte("\n"))
+			stream = bytes.TrimSuffix(stream, []byte("\r"))
+		}
+
+		img, err := decodeImage(dict, stream)
+		if err != nil {
+			return nil, fmt.Errorf("image %d in %s: %w", len(images)+1, filename, err)
+		}
+		images = append(images, img)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images in %s", filename)
+	}
+	return images, nil
+}
+
+func decodeImage(dict, stream []byte) (image.Image, error) {
+	switch {
+	case bytes.Contains(dict, []byte("/DCTDecode")):
+		return jpeg.Decode(bytes.NewReader(stream))
+	case bytes.Contains(dict, []byte("/FlateDecode")):
+	default:
+		return nil, fmt.Errorf("unsupported image filter")
+	}
+
+	width, height := intValue(reWidth, dict), intValue(reHeight, dict)
+	if bits := intValue(reBits, dict); bits != 8 {
+		return nil, fmt.Errorf("unsupported %d bits per component", bits)
+	}
+	channels := 1
+	if bytes.Contains(dict, []byte("/DeviceRGB")) {
+		channels = 3
+	} else if !bytes.Contains(dict, []byte("/DeviceGray")) {
+		return nil, fmt.Errorf("unsupported color space")
+	}
+	if bytes.Contains(dict, []byte("/DecodeParms")) {
+		return nil, fmt.Errorf("predictors are not supported")
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	pix := make([]byte, width*height*channels)
+	_, err = io.ReadFull(zr, pix)
+	if err != nil {
+		return nil, fmt.Errorf("image data: %w", err)
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	if channels == 1 {
+		return &image.Gray{Pix: pix, Stride: width, Rect: rect}, nil
+	}
+	img := image.NewNRGBA(rect)
+	for i := 0; i < width*height; i++ {
+		img.SetNRGBA(i%width, i/width, color.NRGBA{pix[3*i], pix[3*i+1], pix[3*i+2], 255})
+	}
+	return img, nil
+}
+
+func intValue(re *regexp.Regexp, dict []byte) int {
+	m := re.FindSubmatch(dict)
+	if m == nil {
+		return 0
+	}
+	v, _ := strconv.Atoi(string(m[1]))
+	return v
+}
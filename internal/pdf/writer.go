@@ -0,0 +1,183 @@
+// Minimal PDF writer and image extractor for the paper output
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"os"
+	"strings"
+)
+
+// A4 in points
+const (
+	pageWidth  = 595.28
+	pageHeight = 841.89
+	margin     = 36.0
+	fontSize   = 9.0
+	// room for the header and the page number lines
+	textZone = 28.0
+)
+
+// Producer is written to the document info
+const Producer = "bitreel"
+
+// Writer writes a PDF with one image per page and two lines of text, header and footer.
+// Objects are streamed to the file, only the offsets are kept.
+type Writer struct {
+	f       *os.File
+	w       *bufio.Writer
+	offset  int64
+	offsets []int64 // by object number - 1
+	pages   []int
+}
+
+// object numbers written at the end or known up front
+const (
+	objCatalog = 1
+	objPages   = 2
+	objFont    = 3
+	objInfo    = 4
+	objFirst   = 5
+)
+
+func Create(filename string) (*Writer, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f, w: bufio.NewWriter(f)}
+	w.offsets = make([]int64, objFirst-1)
+	// binary comment marks the file as binary for transfer tools
+	w.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	w.object(objFont, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	return w, nil
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	n, _ := fmt.Fprintf(w.w, format, args...)
+	w.offset += int64(n)
+}
+
+func (w *Writer) write(data []byte) {
+	n, _ := w.w.Write(data)
+	w.offset += int64(n)
+}
+
+// newObject reserves the next object number
+func (w *Writer) newObject() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+func (w *Writer) object(num int, dict string) {
+	w.offsets[num-1] = w.offset
+	w.printf("%d 0 obj\n%s\nendobj\n", num, dict)
+}
+
+func (w *Writer) stream(num int, dict string, data []byte) {
+	w.offsets[num-1] = w.offset
+	w.printf("%d 0 obj\n<< %s /Length %d >>\nstream\n", num, dict, len(data))
+	w.write(data)
+	w.printf("\nendstream\nendobj\n")
+}
+
+// AddPage adds the image scaled to fit the page between the header and the footer lines.
+// The image is stored as 8 bit gray, lossless.
+func (w *Writer) AddPage(img image.Image, header, footer string) error {
+	b := img.Bounds()
+	gray := make([]byte, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		if g, ok := img.(*image.Gray); ok {
+			gray = append(gray, g.Pix[g.PixOffset(b.Min.X, y):g.PixOffset(b.Max.X, y)]...)
+			continue
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			gray = append(gray, byte((299*r+587*g+114*bl)/1000>>8))
+		}
+	}
+	var z bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&z, zlib.BestCompression)
+	if err != nil {
+		return err
+	}
+	_, err = zw.Write(gray)
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+
+	imgObj := w.newObject()
+	w.stream(imgObj, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode", b.Dx(), b.Dy()), z.Bytes())
+
+	// fit the image into the box keeping the aspect ratio
+	boxW := pageWidth - 2*margin
+	boxH := pageHeight - 2*margin - 2*textZone
+	scale := boxW / float64(b.Dx())
+	if s := boxH / float64(b.Dy()); s < scale {
+		scale = s
+	}
+	imgW, imgH := float64(b.Dx())*scale, float64(b.Dy())*scale
+	x := (pageWidth - imgW) / 2
+	y := margin + textZone + (boxH-imgH)/2
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im Do Q\n", imgW, imgH, x, y)
+	fmt.Fprintf(&content, "BT /F %.0f Tf %.2f %.2f Td (%s) Tj ET\n", fontSize, margin, pageHeight-margin-fontSize, escape(header))
+	fmt.Fprintf(&content, "BT /F %.0f Tf %.2f %.2f Td (%s) Tj ET\n", fontSize, margin, margin, escape(footer))
+	contentObj := w.newObject()
+	w.stream(contentObj, "", content.Bytes())
+
+	pageObj := w.newObject()
+	w.object(pageObj, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F %d 0 R >> /XObject << /Im %d 0 R >> >> /Contents %d 0 R >>",
+		objPages, pageWidth, pageHeight, objFont, imgObj, contentObj))
+	w.pages = append(w.pages, pageObj)
+	return nil
+}
+
+// Close writes the page tree, the cross reference table and closes the file
+func (w *Writer) Close(title string) error {
+	kids := make([]string, len(w.pages))
+	for i, p := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", p)
+	}
+	w.object(objPages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages)))
+	w.object(objCatalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", objPages))
+	w.object(objInfo, fmt.Sprintf("<< /Title (%s) /Producer (%s) >>", escape(title), Producer))
+
+	xref := w.offset
+	w.printf("xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, off := range w.offsets {
+		w.printf("%010d 00000 n \n", off)
+	}
+	w.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, objCatalog, objInfo, xref)
+	err := w.w.Flush()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// escape makes a PDF literal string, the standard font has no glyphs beyond latin
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// frames written by SaveFrame
+const encodedFramesGlob = "tmp/out/out_*.png"
+
+// MoveFrames moves the encoded frames to the dir as numbered png files
+func MoveFrames(dir string) (int, error) {
+	files, err := filepath.Glob(encodedFramesGlob)
+	if err != nil {
+		return 0, err
+	}
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot create dir %s: %w", dir, err)
+	}
+	for _, file := range files {
+		dst := filepath.Join(dir, filepath.Base(file))
+		err = os.Rename(file, dst)
+		if err != nil {
+			// different device
+			err = moveFile(file, dst)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Cannot move frame %s: %w", file, err)
+		}
+	}
+	return len(files), nil
+}
+
+// ZipFrames packs the encoded frames into a zip archive.
+// Png is compressed already so the files are stored as is.
+func ZipFrames(filename string) (int, error) {
+	files, err := filepath.Glob(encodedFramesGlob)
+	if err != nil {
+		return 0, err
+	}
+	out, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   filepath.Base(file),
+			Method: zip.Store,
+		})
+		if err != nil {
+			return 0, err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return 0, fmt.Errorf("Cannot zip frame %s: %w", file, err)
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		return 0, err
+	}
+	return len(files), out.Close()
+}
+
+// frames are png, scans and photos are jpeg
+func isImage(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// ListFrames returns image files in the dir or matching the glob pattern
+func ListFrames(path string) ([]string, error) {
+	pattern := path
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		pattern = filepath.Join(path, "*")
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var frames []string
+	for _, file := range files {
+		if isImage(file) {
+			frames = append(frames, file)
+		}
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("No png or jpeg frames in %s", path)
+	}
+	sort.Strings(frames)
+	return frames, nil
+}
+
+// UnzipFrames extracts image files from the zip archive into the dir
+func UnzipFrames(filename, dir string) ([]string, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var frames []string
+	for i, zf := range zr.File {
+		if zf.FileInfo().IsDir() || !isImage(zf.Name) {
+			continue
+		}
+		// names from the archive are not trusted
+		dst := filepath.Join(dir, fmt.Sprintf("out_%08d%s", i+1, strings.ToLower(filepath.Ext(zf.Name))))
+		err = unzipFile(zf, dst)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot extract %s: %w", zf.Name, err)
+		}
+		frames = append(frames, dst)
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("No png or jpeg frames in %s", filename)
+	}
+	return frames, nil
+}
+
+func unzipFile(zf *zip.File, dst string) error {
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// moveFile copies the file and removes the source
+func moveFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -4,11 +4,10 @@ package storage
 import (
 	"fmt"
 	"image"
+	_ "image/jpeg" // scans and photos
 	"image/png"
 	"os"
 	"path/filepath"
-	"sort"
-	"strings"
 
 	cfg "github.com/1F47E/go-bitreel/internal/config"
 	"github.com/1F47E/go-bitreel/internal/logger"
@@ -25,32 +24,48 @@ func CreateFramesDir() (string, error) {
 	return framesDir, nil
 }
 
-func ScanFrames() ([]string, error) {
-	files, err := os.ReadDir(framesDir)
+// CreateVolumeDir creates a dir in the frames dir for the frames of one of the inputs
+func CreateVolumeDir(volume int) (string, error) {
+	dir := filepath.Join(framesDir, fmt.Sprintf("vol_%03d", volume))
+	err := os.MkdirAll(dir, os.ModePerm)
 	if err != nil {
-		return nil, err
+		return dir, fmt.Errorf("Error creating frames dir: %s", err)
 	}
-	// filter out files
-	filesList := make([]string, 0, len(files))
-	for _, file := range files {
-		if strings.HasPrefix(file.Name(), "out_") {
-			filesList = append(filesList, framesDir+"/"+file.Name())
-		}
+	return dir, nil
+}
+
+// marker of the complete extraction in the frames dir
+const extractedMarker = ".extracted"
+
+// MarkExtracted records that all the frames of the video are in the dir
+func MarkExtracted(dir, videoFile string) error {
+	id, err := fileIdentity(videoFile)
+	if err != nil {
+		return err
 	}
-	if len(filesList) == 0 {
-		return nil, fmt.Errorf("No files to decode")
+	return os.WriteFile(filepath.Join(dir, extractedMarker), []byte(id), 0644)
+}
+
+// FramesExtracted reports if the dir has all the frames of the same video
+func FramesExtracted(dir, videoFile string) bool {
+	id, err := fileIdentity(videoFile)
+	if err != nil {
+		return false
 	}
-	sort.Strings(filesList)
-	return filesList, nil
+	data, err := os.ReadFile(filepath.Join(dir, extractedMarker))
+	return err == nil && string(data) == id
 }
 
-func CreateTempFile() (*os.File, error) {
-	// Create a temporary file in the same directory
-	tmpFile, err := os.CreateTemp("", "decoded-")
+func fileIdentity(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return tmpFile, nil
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d %d", abs, fi.Size(), fi.ModTime().UnixNano()), nil
 }
 
 // Save decoded
@@ -75,8 +90,10 @@ func SaveDecoded(tmpFile *os.File, filename string) error {
 	return nil
 }
 
-func SaveFrame(frameNum int, img *image.NRGBA) error {
-	filePath := fmt.Sprintf("tmp/out/out_%08d.png", frameNum)
+// SaveFrame writes the frame to a temp file and renames it,
+// so a frame file that exists is complete even after a crash
+func SaveFrame(frameNum int, img *image.Gray) error {
+	filePath := framePath(frameNum)
 	// make sure dir exists - create all
 	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
 	if err != nil {
@@ -85,23 +102,33 @@ func SaveFrame(frameNum int, img *image.NRGBA) error {
 		return fmt.Errorf("Cannot create tmp out dir for path %s: %s", filePath, err)
 	}
 
-	imgFile, err := os.Create(filePath)
-	defer func() {
-		err := imgFile.Close()
-		if err != nil {
-			log.Errorf("Cannot close file: %s", err)
-		}
-	}()
+	partPath := filePath + ".part"
+	imgFile, err := os.Create(partPath)
 	if err != nil {
 		log.Println("Cannot create file:", err)
 		return fmt.Errorf("Cannot create file: %s", err)
 	}
 	err = png.Encode(imgFile, img.SubImage(img.Rect))
 	if err != nil {
+		imgFile.Close()
 		log.Println("Cannot encode to file:", err)
 		return fmt.Errorf("Cannot encode to file: %s", err)
 	}
-	return nil
+	err = imgFile.Close()
+	if err != nil {
+		return fmt.Errorf("Cannot close file: %s", err)
+	}
+	return os.Rename(partPath, filePath)
+}
+
+// FrameSaved reports if the encoded frame file is there
+func FrameSaved(frameNum int) bool {
+	_, err := os.Stat(framePath(frameNum))
+	return err == nil
+}
+
+func framePath(frameNum int) string {
+	return fmt.Sprintf("tmp/out/out_%08d.png", frameNum)
 }
 
 func FrameRead(filaneme string) (image.Image, error) {
@@ -111,9 +138,23 @@ func FrameRead(filaneme string) (image.Image, error) {
 		return nil, err
 	}
 	defer file.Close()
-	img, err := png.Decode(file)
+	img, _, err := image.Decode(file)
 	if err != nil {
 		return nil, err
 	}
 	return img, nil
 }
+
+// SaveImage writes the image as png
+func SaveImage(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, img)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Cannot encode to file: %s", err)
+	}
+	return f.Close()
+}
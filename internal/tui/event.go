@@ -6,12 +6,46 @@ const (
 	eventTypeSpin eventType = iota
 	eventTypeBar
 	eventTypeText
+	eventTypeStage
+	eventTypeProgress
+	eventTypeStats
+	eventTypeWarning
 )
 
+func (t eventType) String() string {
+	switch t {
+	case eventTypeSpin:
+		return "spin"
+	case eventTypeBar:
+		return "bar"
+	case eventTypeText:
+		return "text"
+	case eventTypeStage:
+		return "stage"
+	case eventTypeProgress:
+		return "progress"
+	case eventTypeStats:
+		return "stats"
+	case eventTypeWarning:
+		return "warning"
+	}
+	return "unknown"
+}
+
 type Event struct {
 	eventType eventType
 	text      string
 	percent   float64
+	// progress
+	done  int
+	total int
+	bytes int64
+	// reported by the video backend, 0 - measured from the events
+	fps   float64
+	speed float64
+	// stats
+	corrected int
+	damaged   int
 }
 
 func NewEventSpin(text string) Event {
@@ -35,3 +69,48 @@ func NewEventText(text string) Event {
 		text:      text,
 	}
 }
+
+// NewEventStage starts a stage of the run, the stage before it is done.
+// A stage started again, like for the next volume, makes the stages after it pending.
+func NewEventStage(name string) Event {
+	return Event{
+		eventType: eventTypeStage,
+		text:      name,
+	}
+}
+
+// NewEventProgress of the current stage: frames done of total, 0 if unknown,
+// and the file data through the stage so far, 0 if the stage does not see it
+func NewEventProgress(done, total int, bytes int64) Event {
+	return Event{
+		eventType: eventTypeProgress,
+		done:      done,
+		total:     total,
+		bytes:     bytes,
+	}
+}
+
+// NewEventVideoProgress of the video backend: frames done of total, 0 if unknown,
+// with the fps and the speed to the realtime playback the backend reports
+func NewEventVideoProgress(done, total int, fps, speed float64) Event {
+	e := NewEventProgress(done, total, 0)
+	e.fps, e.speed = fps, speed
+	return e
+}
+
+// NewEventStats adds the corrected pixel errors and the damaged frames to the totals
+func NewEventStats(corrected, damaged int) Event {
+	return Event{
+		eventType: eventTypeStats,
+		corrected: corrected,
+		damaged:   damaged,
+	}
+}
+
+// NewEventWarning of a frame or of the input, shown in the warnings pane
+func NewEventWarning(text string) Event {
+	return Event{
+		eventType: eventTypeWarning,
+		text:      text,
+	}
+}
@@ -0,0 +1,154 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// record of an event in the json output, one per line
+type record struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Text  string    `json:"text,omitempty"`
+	Stage string    `json:"stage,omitempty"`
+	// 0 - 100, bar and progress events
+	Percent *float64 `json:"percent,omitempty"`
+	// progress of the stage
+	Done  int     `json:"done,omitempty"`
+	Total int     `json:"total,omitempty"`
+	MBps  float64 `json:"mb_per_sec,omitempty"`
+	FPS   float64 `json:"fps,omitempty"`
+	// speed to the realtime playback, video stages
+	Speed float64 `json:"speed,omitempty"`
+	ETA   float64 `json:"eta_seconds,omitempty"`
+	// totals of the run
+	Corrected int `json:"corrected,omitempty"`
+	Damaged   int `json:"damaged,omitempty"`
+}
+
+// lines writes the events as lines for log files and pipes.
+// Repeated events are dropped and bars and progress are written when the whole percent changes,
+// or every second if the total is unknown, bar texts carry frame counters, so a long run does not flood the log.
+// Stats are written with the progress and once at the end.
+type lines struct {
+	w     io.Writer
+	json  bool
+	state *state
+	// last written
+	last    Event
+	lastAt  time.Time
+	written bool
+}
+
+func newLines(w io.Writer, json bool) *lines {
+	return &lines{w: w, json: json, state: newState()}
+}
+
+func (l *lines) write(e Event) error {
+	now := time.Now()
+	l.state.apply(e, now)
+	if e.eventType == eventTypeStats || l.repeated(e, now) {
+		return nil
+	}
+	l.last, l.lastAt, l.written = e, now, true
+
+	s := l.state
+	r := record{
+		Time: now,
+		Type: e.eventType.String(),
+		Text: e.text,
+	}
+	line := e.text
+	switch e.eventType {
+	case eventTypeBar:
+		p := float64(percent(e))
+		r.Percent = &p
+		line = fmt.Sprintf("%s %d%%", e.text, percent(e))
+	case eventTypeStage:
+		r.Text, r.Stage = "", e.text
+		line = "Stage: " + e.text
+	case eventTypeProgress:
+		r.Stage = s.stageName()
+		r.Done, r.Total = s.done, s.total
+		r.Corrected, r.Damaged = s.corrected, s.damaged
+		line = s.progressText()
+		if s.total > 0 {
+			p := float64(percent(e))
+			r.Percent = &p
+			line = fmt.Sprintf("%s %d%%, %s", s.stageName(), percent(e), line)
+		} else {
+			line = fmt.Sprintf("%s %s", s.stageName(), line)
+		}
+		if fps, mbps, eta, ok := s.rates(); ok {
+			r.FPS = math.Round(fps*10) / 10
+			r.MBps = math.Round(mbps*10) / 10
+			r.ETA = eta.Seconds()
+			r.Speed = math.Round(s.speed*100) / 100
+		}
+		if s.corrected > 0 || s.damaged > 0 {
+			line += ", " + s.statsText()
+		}
+	case eventTypeWarning:
+		line = "Warning: " + e.text
+	}
+	return l.print(r, line)
+}
+
+// repeated reports if the event adds nothing to the last written one
+func (l *lines) repeated(e Event, now time.Time) bool {
+	if !l.written || e.eventType != l.last.eventType {
+		return false
+	}
+	switch e.eventType {
+	case eventTypeBar, eventTypeProgress:
+		if e.eventType == eventTypeProgress && e.total == 0 {
+			return now.Sub(l.lastAt) < time.Second
+		}
+		return percent(e) == percent(l.last)
+	case eventTypeWarning:
+		return false
+	}
+	return e.text == l.last.text
+}
+
+// finish writes the stats of the run
+func (l *lines) finish() error {
+	s := l.state
+	if s.corrected == 0 && s.damaged == 0 {
+		return nil
+	}
+	return l.print(record{
+		Time:      time.Now(),
+		Type:      eventTypeStats.String(),
+		Corrected: s.corrected,
+		Damaged:   s.damaged,
+	}, s.statsText())
+}
+
+func (l *lines) print(r record, line string) error {
+	if l.json {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(l.w, string(data))
+		return err
+	}
+	_, err := fmt.Fprintf(l.w, "%s %s\n", r.Time.Format(time.RFC3339), line)
+	return err
+}
+
+// percent of a bar or a progress event, whole
+func percent(e Event) int {
+	p := e.percent
+	if e.eventType == eventTypeProgress {
+		if e.total == 0 {
+			return 0
+		}
+		p = float64(e.done) / float64(e.total)
+	}
+	return int(math.Floor(p * 100))
+}
@@ -0,0 +1,49 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLinesVideoProgress(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLines(&buf, true)
+	events := []Event{
+		NewEventStage("Saving video"),
+		NewEventVideoProgress(30, 120, 24.5, 0.82),
+	}
+	for _, e := range events {
+		err := l.write(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var r record
+	err := json.Unmarshal([]byte(lines[len(lines)-1]), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rate of the backend is there from the first report
+	if r.FPS != 24.5 || r.Speed != 0.82 {
+		t.Fatalf("fps %v speed %v, want 24.5 and 0.82", r.FPS, r.Speed)
+	}
+	// 90 frames left at 24.5 fps
+	if r.ETA != 4 {
+		t.Fatalf("eta %v, want 4", r.ETA)
+	}
+
+	buf.Reset()
+	l = newLines(&buf, false)
+	for _, e := range events {
+		err := l.write(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(buf.String(), "24.5 fps, 0.82x") {
+		t.Fatalf("no fps and speed in %q", buf.String())
+	}
+}
@@ -0,0 +1,176 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// warnings kept for the pane, the count is of all of them
+const maxWarnings = 5
+
+type stage struct {
+	name       string
+	start, end time.Time
+}
+
+// state of the run built from the events, the same for the TUI and the lines
+type state struct {
+	stages []stage
+	// index of the running stage, -1 before the first one
+	current int
+
+	// status line of the spin, bar and text events
+	mode    mode
+	title   string
+	percent float64
+
+	// progress of the current stage, rates are measured from the first progress event of the stage,
+	// frames skipped on resume do not count
+	progress    bool
+	done, total int
+	bytes       int64
+	first       time.Time
+	firstDone   int
+	firstBytes  int64
+	last        time.Time
+	// rate reported by the video backend
+	fps, speed float64
+
+	corrected int
+	damaged   int
+
+	warnings      []string
+	warningsTotal int
+}
+
+func newState() *state {
+	return &state{current: -1}
+}
+
+func (s *state) apply(e Event, now time.Time) {
+	switch e.eventType {
+	case eventTypeSpin:
+		s.mode, s.title = spin, e.text
+	case eventTypeBar:
+		s.mode, s.title, s.percent = bar, e.text, e.percent
+	case eventTypeText:
+		s.mode, s.title = text, e.text
+	case eventTypeStage:
+		s.startStage(e.text, now)
+	case eventTypeProgress:
+		if !s.progress {
+			s.progress = true
+			s.first, s.firstDone, s.firstBytes = now, e.done, e.bytes
+		}
+		s.done, s.total, s.bytes, s.last = e.done, e.total, e.bytes, now
+		s.fps, s.speed = e.fps, e.speed
+		if e.total > 0 {
+			s.mode, s.percent = bar, float64(e.done)/float64(e.total)
+			if s.percent > 1 {
+				s.percent = 1
+			}
+		}
+	case eventTypeStats:
+		s.corrected += e.corrected
+		s.damaged += e.damaged
+	case eventTypeWarning:
+		s.warningsTotal++
+		s.warnings = append(s.warnings, e.text)
+		if len(s.warnings) > maxWarnings {
+			s.warnings = s.warnings[1:]
+		}
+	}
+}
+
+func (s *state) startStage(name string, now time.Time) {
+	if s.current >= 0 && s.stages[s.current].end.IsZero() {
+		s.stages[s.current].end = now
+	}
+	s.current = -1
+	for i := range s.stages {
+		if s.stages[i].name == name {
+			s.current = i
+			break
+		}
+	}
+	if s.current < 0 {
+		s.stages = append(s.stages, stage{name: name})
+		s.current = len(s.stages) - 1
+	}
+	s.stages[s.current] = stage{name: name, start: now}
+	for i := s.current + 1; i < len(s.stages); i++ {
+		s.stages[i].start, s.stages[i].end = time.Time{}, time.Time{}
+	}
+	s.mode, s.title, s.percent = spin, "", 0
+	s.progress = false
+	s.done, s.total, s.bytes = 0, 0, 0
+	s.fps, s.speed = 0, 0
+}
+
+// finish ends the running stage
+func (s *state) finish(now time.Time) {
+	if s.current >= 0 && s.stages[s.current].end.IsZero() {
+		s.stages[s.current].end = now
+	}
+}
+
+// rates of the current stage, the fps reported by the video backend goes first,
+// ok is false until there are frames to measure
+func (s *state) rates() (fps, mbps float64, eta time.Duration, ok bool) {
+	if !s.progress {
+		return 0, 0, 0, false
+	}
+	elapsed := s.last.Sub(s.first).Seconds()
+	frames := s.done - s.firstDone
+	switch {
+	case s.fps > 0:
+		fps = s.fps
+	case elapsed > 0 && frames > 0:
+		fps = float64(frames) / elapsed
+	default:
+		return 0, 0, 0, false
+	}
+	if elapsed > 0 {
+		mbps = float64(s.bytes-s.firstBytes) / (1 << 20) / elapsed
+	}
+	if s.total > s.done {
+		eta = time.Duration(float64(s.total-s.done) / fps * float64(time.Second)).Round(time.Second)
+	}
+	return fps, mbps, eta, true
+}
+
+// progressText of the current stage: frames, throughput and ETA
+func (s *state) progressText() string {
+	var parts []string
+	if s.total > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d frames", s.done, s.total))
+	} else {
+		parts = append(parts, fmt.Sprintf("%d frames", s.done))
+	}
+	if fps, mbps, eta, ok := s.rates(); ok {
+		if mbps > 0 {
+			parts = append(parts, fmt.Sprintf("%.1f MB/s", mbps))
+		}
+		parts = append(parts, fmt.Sprintf("%.1f fps", fps))
+		if s.speed > 0 {
+			parts = append(parts, fmt.Sprintf("%.2fx", s.speed))
+		}
+		if eta > 0 {
+			parts = append(parts, "ETA "+eta.String())
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s *state) statsText() string {
+	return fmt.Sprintf("Corrected pixel errors: %d, damaged frames: %d", s.corrected, s.damaged)
+}
+
+// stageName of the running stage, empty before the first one
+func (s *state) stageName() string {
+	if s.current < 0 {
+		return ""
+	}
+	return s.stages[s.current].name
+}
@@ -2,37 +2,122 @@ package tui
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/1F47E/go-bitreel/internal/logger"
+	"golang.org/x/term"
+)
+
+// Mode of the progress output
+type Mode string
+
+const (
+	// tui on a terminal, plain otherwise
+	ModeAuto Mode = "auto"
+	// spinner and progress bar
+	ModeTUI Mode = "tui"
+	// an event per line as json
+	ModeJSON Mode = "json"
+	// an event per line as text
+	ModePlain Mode = "plain"
+	// no progress
+	ModeNone Mode = "none"
 )
 
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeAuto, ModeTUI, ModeJSON, ModePlain, ModeNone:
+		return m, nil
+	case "":
+		return ModeAuto, nil
+	}
+	return "", fmt.Errorf("unknown progress %q, use auto, tui, json, plain or none", s)
+}
+
+// Resolve picks the output of the auto mode, the TUI needs a terminal for the input and the output
+func (m Mode) Resolve() Mode {
+	if m != ModeAuto {
+		return m
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd())) {
+		return ModeTUI
+	}
+	return ModePlain
+}
+
 type TUI struct {
-	ctx      context.Context
+	ctx context.Context
+	// cancels the run from the TUI keys
+	cancel   context.CancelFunc
 	eventsCh chan Event
+	done     chan struct{}
 }
 
-func New(eventsCh chan Event, ctx context.Context) *TUI {
-	return &TUI{ctx, eventsCh}
+func New(eventsCh chan Event, ctx context.Context, cancel context.CancelFunc) *TUI {
+	return &TUI{ctx, cancel, eventsCh, make(chan struct{})}
 }
 
-func (t *TUI) Run() {
-	// init bubbletea spinner and progress bar
-	widget := NewWidget()
+// Run renders the events in the mode until the events channel is closed.
+// Lines of the json and plain modes go to stderr, stdout is kept for the command output.
+func (t *TUI) Run(mode Mode) {
+	defer close(t.done)
+	switch mode.Resolve() {
+	case ModeTUI:
+		t.runWidget()
+	case ModeJSON:
+		t.runLines(newLines(os.Stderr, true))
+	case ModePlain:
+		t.runLines(newLines(os.Stderr, false))
+	default:
+		for range t.eventsCh {
+		}
+	}
+}
+
+// Stop closes the events channel and waits for the last events to be written.
+// The events must not be sent after.
+func (t *TUI) Stop() {
+	close(t.eventsCh)
+	<-t.done
+}
+
+func (t *TUI) runLines(l *lines) {
+	for event := range t.eventsCh {
+		if err := l.write(event); err != nil {
+			// the log is gone, keep draining so the core is not blocked
+			l.w = io.Discard
+		}
+	}
+	_ = l.finish()
+}
+
+func (t *TUI) runWidget() {
+	// init bubbletea view
+	widget := NewWidget(t.cancel)
 	go widget.Run()
+	// warnings of all the packages go to the pane, not over the view
+	restore := logger.Divert(func(msg string) {
+		widget.Send(NewEventWarning(msg))
+	})
 
-	// read events from channel and update spinner/progress bar
+	// pass events to the view until the run is stopped
+	cancelled := t.ctx.Done()
 	for {
 		select {
-		case <-t.ctx.Done():
-			return
-
-		case event := <-t.eventsCh:
-			switch event.eventType {
-			case eventTypeSpin:
-				widget.SetSpinner(event.text)
-			case eventTypeBar:
-				widget.SetProgress(event.text, event.percent)
-			case eventTypeText:
-				widget.SetText(event.text)
+		case <-cancelled:
+			widget.Cancelled()
+			cancelled = nil
+
+		case event, ok := <-t.eventsCh:
+			if !ok {
+				// the final view stays on the screen, the logs go after it
+				widget.Quit()
+				restore()
+				return
 			}
+			widget.Send(event)
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -19,6 +20,12 @@ const (
 
 type tickMsg time.Time
 
+// cancelMsg is sent when the run is cancelled by a signal
+type cancelMsg struct{}
+
+// finishMsg is sent before the view quits at the end of the run
+type finishMsg struct{}
+
 type mode int
 
 const (
@@ -27,46 +34,68 @@ const (
 	text
 )
 
+var (
+	styleDone    = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	styleFailed  = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	stylePending = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	styleWarning = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+)
+
+// Widget renders the events as a stage list, a progress bar with the throughput,
+// the stats and the last warnings. Events come as messages from any goroutine with Send.
+// q or Ctrl-C cancels the run, the view stays until the run is stopped, a second Ctrl-C leaves it.
 type Widget struct {
-	mode     mode
-	title    string
-	spinner  spinner.Model
-	progress progress.Model
-	percent  float64
+	program    *tea.Program
+	cancel     context.CancelFunc
+	state      *state
+	spinner    spinner.Model
+	progress   progress.Model
+	width      int
+	cancelling bool
+	finished   bool
+	done       chan struct{}
 }
 
-func NewWidget() *Widget {
+func NewWidget(cancel context.CancelFunc) *Widget {
 	s := spinner.New()
 	s.Spinner = spinner.Line
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
 
-	return &Widget{
+	w := &Widget{
+		cancel:   cancel,
+		state:    newState(),
 		spinner:  s,
 		progress: progress.New(progress.WithDefaultGradient()),
-		percent:  0,
+		width:    maxWidth,
+		done:     make(chan struct{}),
 	}
+	// keys and signals cancel the run, not the view
+	w.program = tea.NewProgram(w, tea.WithoutSignalHandler())
+	return w
 }
 
-func (w *Widget) SetProgress(title string, percent float64) {
-	w.mode = bar
-	w.title = title
-	w.percent = percent
+// Send passes the event to the view, does nothing after the view quits
+func (w *Widget) Send(e Event) {
+	w.program.Send(e)
 }
 
-func (w *Widget) SetSpinner(title string) {
-	w.mode = spin
-	w.title = title
+// Cancelled shows the run is being cancelled
+func (w *Widget) Cancelled() {
+	w.program.Send(cancelMsg{})
 }
 
-func (w *Widget) SetText(title string) {
-	w.mode = text
-	w.title = title
+// Quit renders the final state, restores the terminal and waits for it
+func (w *Widget) Quit() {
+	w.program.Send(finishMsg{})
+	w.program.Quit()
+	<-w.done
 }
 
 func (w *Widget) Run() {
-	if _, err := tea.NewProgram(w).Run(); err != nil {
-		fmt.Println("Oh no!", err)
-		os.Exit(1)
+	defer close(w.done)
+	if _, err := w.program.Run(); err != nil {
+		// the run goes on without the view
+		fmt.Fprintln(os.Stderr, "Oh no!", err)
 	}
 }
 
@@ -76,19 +105,44 @@ func (w *Widget) Init() tea.Cmd {
 
 func (w *Widget) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case Event:
+		w.state.apply(msg, time.Now())
+		return w, nil
+
 	case tea.KeyMsg:
-		return w, tea.Quit
+		switch msg.String() {
+		case "ctrl+c":
+			if w.cancelling {
+				return w, tea.Quit
+			}
+			fallthrough
+		case "q":
+			w.cancelling = true
+			w.cancel()
+		}
+		return w, nil
+
+	case cancelMsg:
+		w.cancelling = true
+		return w, nil
+
+	case finishMsg:
+		w.finished = true
+		if !w.cancelling {
+			w.state.finish(time.Now())
+		}
+		return w, nil
 
 	case tea.WindowSizeMsg:
-		w.progress.Width = msg.Width - padding*2 - 4
-		if w.progress.Width > maxWidth {
-			w.progress.Width = maxWidth
+		w.width = msg.Width - padding*2
+		if w.width > maxWidth {
+			w.width = maxWidth
 		}
+		w.progress.Width = w.width - 4
 		return w, nil
 
 	case tickMsg:
-
-		cmd := w.progress.SetPercent(w.percent)
+		cmd := w.progress.SetPercent(w.state.percent)
 		return w, tea.Batch(tickCmd(), cmd)
 
 	// FrameMsg is sent when the progress bar wants to animate itself
@@ -107,22 +161,73 @@ func (w *Widget) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (w *Widget) View() string {
 	pad := strings.Repeat(" ", padding)
+	s := w.state
+	var b strings.Builder
+	b.WriteString("\n")
+
+	// stage checklist, the title goes under it
+	for i, st := range s.stages {
+		var mark string
+		switch {
+		case !st.end.IsZero():
+			mark = styleDone.Render("✓") + " " + st.name + " " + stylePending.Render(st.end.Sub(st.start).Round(100*time.Millisecond).String())
+		case i == s.current && w.cancelling:
+			mark = styleFailed.Render("✗") + " " + st.name
+		case i == s.current:
+			mark = w.spinner.View() + " " + st.name
+		default:
+			mark = stylePending.Render("· " + st.name)
+		}
+		b.WriteString(pad + mark + "\n")
+	}
+	if len(s.stages) > 0 {
+		b.WriteString("\n")
+	}
+
+	if s.title != "" {
+		if s.mode == spin && len(s.stages) == 0 && !w.finished {
+			b.WriteString(pad + w.spinner.View() + " " + s.title + "\n\n")
+		} else {
+			b.WriteString(pad + s.title + "\n\n")
+		}
+	}
+	if s.mode == bar {
+		b.WriteString(pad + w.progress.View() + "\n")
+	}
+	if s.progress {
+		b.WriteString(pad + s.progressText() + "\n")
+	}
+	if s.corrected > 0 || s.damaged > 0 {
+		b.WriteString(pad + s.statsText() + "\n")
+	}
 
-	if w.mode == text {
-		return fmt.Sprintf("\n\n%s%s\n\n", pad, w.title)
-	} else if w.mode == spin {
-		return fmt.Sprintf("\n\n%s%s %s\n\n", pad, w.spinner.View(), w.title)
+	if s.warningsTotal > 0 {
+		b.WriteString("\n" + pad + styleWarning.Render(fmt.Sprintf("Warnings (%d)", s.warningsTotal)) + "\n")
+		for _, warning := range s.warnings {
+			b.WriteString(pad + styleWarning.Render("! ") + truncate(warning, w.width-2) + "\n")
+		}
+	}
+
+	switch {
+	case w.cancelling:
+		b.WriteString("\n" + pad + styleFailed.Render("Cancelling...") + "\n")
+	case !w.finished:
+		b.WriteString("\n" + pad + stylePending.Render("q cancel") + "\n")
+	}
+	return b.String()
+}
 
-	} else if w.mode == bar {
-		return "\n" +
-			pad + w.title + "\n\n" +
-			pad + w.progress.View() + "\n"
+// truncate to the width in runes, the view does not wrap
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if width < 1 || len(r) <= width {
+		return s
 	}
-	return ""
+	return string(r[:width-1]) + "…"
 }
 
 func tickCmd() tea.Cmd {
-	return tea.Tick(time.Microsecond*100, func(t time.Time) tea.Msg {
+	return tea.Tick(time.Second/10, func(t time.Time) tea.Msg {
 		return tickMsg(t)
 	})
 }
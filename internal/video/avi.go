@@ -0,0 +1,500 @@
+package video
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// AVI container with PNG frames (MPNG), readable by ffmpeg and most players.
+//
+//	RIFF AVI
+//	  LIST hdrl
+//	    avih
+//	    LIST strl - video: strh, strf BITMAPINFOHEADER
+//	    LIST strl - audio, optional: strh, strf WAVEFORMATEX
+//	    LIST odml - dmlh with the total frames count
+//	  LIST INFO - INAM title, ICMT comment
+//	  LIST movi - 00dc png frames, 01wb pcm audio
+//	  idx1
+//	RIFF AVIX - OpenDML extension over the first 1GB
+//	  LIST movi
+//
+// Only the first RIFF has the legacy index, the reader scans the chunks.
+const (
+	aviCodec      = "MPNG"
+	aviVideoChunk = "00dc"
+	aviAudioChunk = "01wb"
+	// AVI 1.0 readers stop at 1GB, the rest goes to AVIX lists
+	aviRIFFLimit = 1 << 30
+
+	aviFlagHasIndex = 0x10
+	aviFlagKeyframe = 0x10
+)
+
+// tags stored in the INFO list
+var aviTags = map[string]string{
+	"title":   "INAM",
+	"comment": "ICMT",
+}
+
+type aviIndexEntry struct {
+	id     string
+	offset uint32
+	size   uint32
+}
+
+type aviWriter struct {
+	f          *os.File
+	width      int
+	height     int
+	fps        int
+	sampleRate int // 0 - no audio stream
+	tags       map[string]string
+	riffLimit  int64
+
+	first     bool  // writing the first RIFF with the legacy index
+	riffPos   int64 // offset of the current RIFF chunk
+	moviPos   int64 // offset of the current movi LIST chunk
+	index     []aviIndexEntry
+	frames    int
+	framesIdx int // frames in the first RIFF
+	samples   int
+	maxChunk  int
+}
+
+func newAVIWriter(f *os.File, width, height, fps, sampleRate int, tags map[string]string) (*aviWriter, error) {
+	w := &aviWriter{
+		f:          f,
+		width:      width,
+		height:     height,
+		fps:        fps,
+		sampleRate: sampleRate,
+		tags:       tags,
+		riffLimit:  aviRIFFLimit,
+		first:      true,
+	}
+	// header is written with zero counters and rewritten on close
+	_, err := f.Write(append([]byte("RIFF\x00\x00\x00\x00AVI "), w.header()...))
+	if err != nil {
+		return nil, err
+	}
+	return w, w.startMovi()
+}
+
+// header of the first RIFF, fixed size for the same settings
+func (w *aviWriter) header() []byte {
+	le := binary.LittleEndian
+
+	avih := make([]byte, 0, 56)
+	avih = le.AppendUint32(avih, uint32(1000000/w.fps))
+	avih = le.AppendUint32(avih, 0) // max bytes per sec
+	avih = le.AppendUint32(avih, 0) // padding
+	avih = le.AppendUint32(avih, aviFlagHasIndex)
+	avih = le.AppendUint32(avih, uint32(w.framesIdx))
+	avih = le.AppendUint32(avih, 0) // initial frames
+	streams := 1
+	if w.sampleRate > 0 {
+		streams++
+	}
+	avih = le.AppendUint32(avih, uint32(streams))
+	avih = le.AppendUint32(avih, uint32(w.maxChunk))
+	avih = le.AppendUint32(avih, uint32(w.width))
+	avih = le.AppendUint32(avih, uint32(w.height))
+	avih = append(avih, make([]byte, 16)...)
+
+	vstrh := make([]byte, 0, 56)
+	vstrh = append(vstrh, "vids"+aviCodec...)
+	vstrh = le.AppendUint32(vstrh, 0) // flags
+	vstrh = le.AppendUint32(vstrh, 0) // priority, language
+	vstrh = le.AppendUint32(vstrh, 0) // initial frames
+	vstrh = le.AppendUint32(vstrh, 1) // scale
+	vstrh = le.AppendUint32(vstrh, uint32(w.fps))
+	vstrh = le.AppendUint32(vstrh, 0) // start
+	vstrh = le.AppendUint32(vstrh, uint32(w.frames))
+	vstrh = le.AppendUint32(vstrh, uint32(w.maxChunk))
+	vstrh = le.AppendUint32(vstrh, 0xFFFFFFFF) // quality
+	vstrh = le.AppendUint32(vstrh, 0)          // sample size
+	vstrh = le.AppendUint16(vstrh, 0)
+	vstrh = le.AppendUint16(vstrh, 0)
+	vstrh = le.AppendUint16(vstrh, uint16(w.width))
+	vstrh = le.AppendUint16(vstrh, uint16(w.height))
+
+	vstrf := make([]byte, 0, 40)
+	vstrf = le.AppendUint32(vstrf, 40)
+	vstrf = le.AppendUint32(vstrf, uint32(w.width))
+	vstrf = le.AppendUint32(vstrf, uint32(w.height))
+	vstrf = le.AppendUint16(vstrf, 1)  // planes
+	vstrf = le.AppendUint16(vstrf, 24) // bit count
+	vstrf = append(vstrf, aviCodec...)
+	vstrf = le.AppendUint32(vstrf, uint32(w.width*w.height*3))
+	vstrf = append(vstrf, make([]byte, 16)...)
+
+	strls := aviList("strl", aviChunk("strh", vstrh), aviChunk("strf", vstrf))
+
+	if w.sampleRate > 0 {
+		astrh := make([]byte, 0, 56)
+		astrh = append(astrh, "auds"...)
+		astrh = le.AppendUint32(astrh, 0) // handler
+		astrh = le.AppendUint32(astrh, 0) // flags
+		astrh = le.AppendUint32(astrh, 0) // priority, language
+		astrh = le.AppendUint32(astrh, 0) // initial frames
+		astrh = le.AppendUint32(astrh, 1) // scale
+		astrh = le.AppendUint32(astrh, uint32(w.sampleRate))
+		astrh = le.AppendUint32(astrh, 0) // start
+		astrh = le.AppendUint32(astrh, uint32(w.samples))
+		astrh = le.AppendUint32(astrh, uint32(w.sampleRate/w.fps*2))
+		astrh = le.AppendUint32(astrh, 0xFFFFFFFF) // quality
+		astrh = le.AppendUint32(astrh, 2)          // sample size
+		astrh = append(astrh, make([]byte, 8)...)
+
+		astrf := make([]byte, 0, 18)
+		astrf = le.AppendUint16(astrf, 1) // PCM
+		astrf = le.AppendUint16(astrf, 1) // mono
+		astrf = le.AppendUint32(astrf, uint32(w.sampleRate))
+		astrf = le.AppendUint32(astrf, uint32(w.sampleRate*2))
+		astrf = le.AppendUint16(astrf, 2)
+		astrf = le.AppendUint16(astrf, 16)
+		astrf = le.AppendUint16(astrf, 0)
+
+		strls = append(strls, aviList("strl", aviChunk("strh", astrh), aviChunk("strf", astrf))...)
+	}
+
+	dmlh := make([]byte, 248)
+	le.PutUint32(dmlh, uint32(w.frames))
+
+	hdrl := aviList("hdrl",
+		aviChunk("avih", avih),
+		strls,
+		aviList("odml", aviChunk("dmlh", dmlh)),
+	)
+
+	var info [][]byte
+	for _, name := range []string{"title", "comment"} {
+		if v, ok := w.tags[name]; ok {
+			info = append(info, aviChunk(aviTags[name], append([]byte(v), 0)))
+		}
+	}
+	if len(info) > 0 {
+		hdrl = append(hdrl, aviList("INFO", info...)...)
+	}
+	return hdrl
+}
+
+func (w *aviWriter) startMovi() error {
+	pos, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	w.moviPos = pos
+	_, err = w.f.Write([]byte("LIST\x00\x00\x00\x00movi"))
+	return err
+}
+
+func (w *aviWriter) WriteFrame(data []byte) error {
+	w.frames++
+	if w.first {
+		w.framesIdx++
+	}
+	return w.writeChunk(aviVideoChunk, data)
+}
+
+func (w *aviWriter) WriteAudio(samples []int16) error {
+	data := make([]byte, 0, len(samples)*2)
+	for _, s := range samples {
+		data = binary.LittleEndian.AppendUint16(data, uint16(s))
+	}
+	w.samples += len(samples)
+	return w.writeChunk(aviAudioChunk, data)
+}
+
+func (w *aviWriter) writeChunk(id string, data []byte) error {
+	pos, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if pos-w.riffPos+int64(len(data)) > w.riffLimit {
+		err = w.finishRIFF()
+		if err != nil {
+			return err
+		}
+		// OpenDML extension RIFF
+		w.riffPos, err = w.f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		_, err = w.f.Write([]byte("RIFF\x00\x00\x00\x00AVIX"))
+		if err != nil {
+			return err
+		}
+		err = w.startMovi()
+		if err != nil {
+			return err
+		}
+		pos, _ = w.f.Seek(0, io.SeekCurrent)
+	}
+	if w.first {
+		// offsets are from the movi fourcc
+		w.index = append(w.index, aviIndexEntry{id, uint32(pos - w.moviPos - 8), uint32(len(data))})
+	}
+	if len(data) > w.maxChunk {
+		w.maxChunk = len(data)
+	}
+	_, err = w.f.Write(aviChunk(id, data))
+	return err
+}
+
+// finishRIFF closes the movi list, writes the index in the first RIFF and patches the sizes
+func (w *aviWriter) finishRIFF() error {
+	end, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	err = w.patchSize(w.moviPos, end)
+	if err != nil {
+		return err
+	}
+	if w.first {
+		idx := make([]byte, 0, 16*len(w.index))
+		for _, e := range w.index {
+			flags := uint32(0)
+			if e.id == aviVideoChunk {
+				flags = aviFlagKeyframe
+			}
+			idx = append(idx, e.id...)
+			idx = binary.LittleEndian.AppendUint32(idx, flags)
+			idx = binary.LittleEndian.AppendUint32(idx, e.offset)
+			idx = binary.LittleEndian.AppendUint32(idx, e.size)
+		}
+		_, err = w.f.Write(aviChunk("idx1", idx))
+		if err != nil {
+			return err
+		}
+		w.index = nil
+		w.first = false
+		end += int64(8 + len(idx))
+	}
+	return w.patchSize(w.riffPos, end)
+}
+
+// patchSize writes the size of the chunk at pos ending at end and seeks back to the end
+func (w *aviWriter) patchSize(pos, end int64) error {
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(end-pos-8))
+	_, err := w.f.WriteAt(size, pos+4)
+	if err != nil {
+		return err
+	}
+	_, err = w.f.Seek(end, io.SeekStart)
+	return err
+}
+
+func (w *aviWriter) Close() error {
+	err := w.finishRIFF()
+	if err != nil {
+		return err
+	}
+	// header with the final counters
+	_, err = w.f.WriteAt(w.header(), 12)
+	return err
+}
+
+func aviChunk(id string, data []byte) []byte {
+	chunk := make([]byte, 0, 8+len(data)+1)
+	chunk = append(chunk, id...)
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, data...)
+	// chunks are word aligned
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func aviList(listType string, children ...[]byte) []byte {
+	data := []byte(listType)
+	for _, c := range children {
+		data = append(data, c...)
+	}
+	return aviChunk("LIST", data)
+}
+
+// aviInfo is the stream info read from the headers
+type aviInfo struct {
+	width      int
+	height     int
+	fps        int
+	codec      string
+	frames     int
+	sampleRate int
+	tags       map[string]string
+}
+
+var errStopWalk = errors.New("stop")
+
+// readAVI parses the headers and calls chunk for every data chunk in order.
+// With chunk nil the data lists are skipped.
+func readAVI(filename string, chunk func(id string, data []byte) error) (*aviInfo, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 12)
+	_, err = io.ReadFull(f, head)
+	if err != nil || string(head[:4]) != "RIFF" || string(head[8:]) != "AVI " {
+		return nil, fmt.Errorf("%s is not an AVI file", filename)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &aviInfo{tags: make(map[string]string)}
+	r := &aviReader{f: f, info: info, chunk: chunk}
+	err = r.walk(fi.Size())
+	if err != nil && err != errStopWalk {
+		return nil, err
+	}
+	return info, nil
+}
+
+type aviReader struct {
+	f      *os.File
+	info   *aviInfo
+	chunk  func(id string, data []byte) error
+	stream string // type of the last stream header
+	dmlh   bool
+}
+
+// walk visits chunks up to end, lists are descended into
+func (r *aviReader) walk(end int64) error {
+	le := binary.LittleEndian
+	head := make([]byte, 12)
+	for {
+		pos, err := r.f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if pos+8 > end {
+			return nil
+		}
+		_, err = io.ReadFull(r.f, head[:8])
+		if err != nil {
+			// truncated file, keep what was read
+			return nil
+		}
+		id := string(head[:4])
+		size := int64(le.Uint32(head[4:8]))
+		next := pos + 8 + size + size%2
+		if next > end {
+			next = end
+		}
+
+		if id == "RIFF" || id == "LIST" {
+			_, err = io.ReadFull(r.f, head[8:12])
+			if err != nil {
+				return nil
+			}
+			if string(head[8:12]) != "movi" || r.chunk != nil {
+				err = r.walk(next)
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			err = r.visit(id, size)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = r.f.Seek(next, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *aviReader) visit(id string, size int64) error {
+	le := binary.LittleEndian
+	read := func() ([]byte, error) {
+		data := make([]byte, size)
+		_, err := io.ReadFull(r.f, data)
+		return data, err
+	}
+	info := r.info
+
+	switch {
+	case id == "avih":
+		data, err := read()
+		if err != nil || len(data) < 40 {
+			return fmt.Errorf("broken AVI header")
+		}
+		if us := le.Uint32(data[0:4]); us > 0 && info.fps == 0 {
+			info.fps = int(1000000 / us)
+		}
+		if !r.dmlh {
+			info.frames = int(le.Uint32(data[16:20]))
+		}
+		info.width = int(le.Uint32(data[32:36]))
+		info.height = int(le.Uint32(data[36:40]))
+	case id == "strh":
+		data, err := read()
+		if err != nil || len(data) < 32 {
+			return fmt.Errorf("broken AVI stream header")
+		}
+		r.stream = string(data[0:4])
+		if r.stream == "vids" {
+			scale, rate := le.Uint32(data[20:24]), le.Uint32(data[24:28])
+			if scale > 0 {
+				info.fps = int(rate / scale)
+			}
+		}
+	case id == "strf":
+		data, err := read()
+		if err != nil {
+			return err
+		}
+		switch {
+		case r.stream == "vids" && len(data) >= 20:
+			info.codec = string(data[16:20])
+		case r.stream == "auds" && len(data) >= 16:
+			if le.Uint16(data[0:2]) != 1 || le.Uint16(data[2:4]) != 1 || le.Uint16(data[14:16]) != 16 {
+				return fmt.Errorf("unsupported AVI audio format, 16 bit mono PCM expected")
+			}
+			info.sampleRate = int(le.Uint32(data[4:8]))
+		}
+	case id == "dmlh":
+		data, err := read()
+		if err == nil && len(data) >= 4 {
+			r.dmlh = true
+			info.frames = int(le.Uint32(data[0:4]))
+		}
+	case id == "INAM" || id == "ICMT":
+		data, err := read()
+		if err != nil {
+			return err
+		}
+		for name, tag := range aviTags {
+			if tag == id {
+				info.tags[name] = strings.TrimRight(string(data), "\x00")
+			}
+		}
+	case r.chunk != nil && (strings.HasSuffix(id, "dc") || strings.HasSuffix(id, "wb")):
+		data, err := read()
+		if err != nil {
+			// truncated last chunk
+			return errStopWalk
+		}
+		return r.chunk(id, data)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package video
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type aviChunkRead struct {
+	id   string
+	data []byte
+}
+
+// writeTestAVI writes frames with an audio chunk after every frame,
+// riffLimit 0 keeps the default
+func writeTestAVI(t *testing.T, frames [][]byte, riffLimit int64) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "out.avi")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tags := map[string]string{"title": "file.bin", "comment": `{"filename":"file.bin"}`}
+	w, err := newAVIWriter(f, 64, 32, 30, 48000, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if riffLimit > 0 {
+		w.riffLimit = riffLimit
+	}
+	for _, frame := range frames {
+		err = w.WriteFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.WriteAudio([]int16{1, -1, 300})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func testFrames(n int) [][]byte {
+	frames := make([][]byte, n)
+	for i := range frames {
+		// odd sizes are padded in the file
+		frames[i] = bytes.Repeat([]byte(fmt.Sprint(i)), 1000+i)
+	}
+	return frames
+}
+
+func TestAVIRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		riffLimit int64
+		avix      bool
+	}{
+		{"one riff", 0, false},
+		// every RIFF holds a few chunks
+		{"avix", 4096, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := testFrames(10)
+			filename := writeTestAVI(t, frames, tt.riffLimit)
+			file, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if avix := bytes.Contains(file, []byte("AVIX")); avix != tt.avix {
+				t.Fatalf("AVIX list %v, want %v", avix, tt.avix)
+			}
+
+			var chunks []aviChunkRead
+			info, err := readAVI(filename, func(id string, data []byte) error {
+				chunks = append(chunks, aviChunkRead{id, data})
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &aviInfo{
+				width:      64,
+				height:     32,
+				fps:        30,
+				codec:      aviCodec,
+				frames:     len(frames),
+				sampleRate: 48000,
+				tags:       map[string]string{"title": "file.bin", "comment": `{"filename":"file.bin"}`},
+			}
+			if !reflect.DeepEqual(info, want) {
+				t.Fatalf("info %+v, want %+v", info, want)
+			}
+			if len(chunks) != 2*len(frames) {
+				t.Fatalf("%d chunks, want %d", len(chunks), 2*len(frames))
+			}
+			for i, frame := range frames {
+				video, audio := chunks[2*i], chunks[2*i+1]
+				if video.id != aviVideoChunk || !bytes.Equal(video.data, frame) {
+					t.Fatalf("chunk %d: %s of %d bytes, want frame %d", 2*i, video.id, len(video.data), i)
+				}
+				if audio.id != aviAudioChunk || !bytes.Equal(audio.data, []byte{1, 0, 0xff, 0xff, 0x2c, 0x01}) {
+					t.Fatalf("chunk %d: %s %v, want the audio", 2*i+1, audio.id, audio.data)
+				}
+			}
+		})
+	}
+}
+
+func TestAVITruncated(t *testing.T) {
+	frames := testFrames(5)
+	filename := writeTestAVI(t, frames, 4096)
+	file, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut in the middle of the last frame, before its audio chunk of 14 bytes
+	err = os.WriteFile(filename, file[:len(file)-14-100], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read int
+	_, err = readAVI(filename, func(id string, data []byte) error {
+		if id == aviVideoChunk {
+			read++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != len(frames)-1 {
+		t.Fatalf("%d frames read, want %d", read, len(frames)-1)
+	}
+}
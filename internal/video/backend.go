@@ -0,0 +1,53 @@
+package video
+
+import (
+	"context"
+	"fmt"
+)
+
+// Backend names for the --backend flag
+const (
+	BackendAuto   = "auto"
+	BackendFFmpeg = "ffmpeg"
+	BackendNative = "native"
+)
+
+// Backend writes frames into a video and reads them back
+type Backend interface {
+	Name() string
+	// EncodeFrames encodes the frames written by the encoder into out,
+	// audio is an optional wav file, tags go to the container metadata
+	EncodeFrames(ctx context.Context, out, audio string, tags map[string]string, progress func(Progress)) error
+	ExtractFrames(ctx context.Context, filename, dir string, progress func(Progress)) error
+	ExtractAudio(ctx context.Context, filename, out string, sampleRate int) error
+	ExtractFirstFrame(ctx context.Context, filename, out string) error
+	ProbeTags(ctx context.Context, filename string) (map[string]string, error)
+	ProbeFrames(ctx context.Context, filename string) (int, error)
+	// FrameSize returns the bytes the png frame takes in a video, for the volumes split by size
+	FrameSize(ctx context.Context, frame string) (int64, error)
+}
+
+// NewBackend returns the backend by name.
+// On encoding filename is empty and auto picks ffmpeg if it is found.
+// On decoding auto picks the native backend for its own files, ffmpeg for the rest.
+// ff has the ffmpeg settings, used if ffmpeg is picked.
+func NewBackend(name, filename string, ff FFmpeg) (Backend, error) {
+	switch name {
+	case BackendFFmpeg:
+		return ff, nil
+	case BackendNative:
+		return Native{}, nil
+	case BackendAuto, "":
+		if filename != "" {
+			if (Native{}).CanRead(filename) {
+				return Native{}, nil
+			}
+			return ff, nil
+		}
+		if Locate() != nil {
+			return Native{}, nil
+		}
+		return ff, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q, use %s, %s or %s", name, BackendAuto, BackendFFmpeg, BackendNative)
+}
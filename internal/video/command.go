@@ -0,0 +1,210 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/1F47E/go-bitreel/internal/logger"
+)
+
+// Command is an ffmpeg invocation built from typed parts,
+// every path and value is a separate argument so spaces are safe
+type Command struct {
+	global   []string
+	inputs   []*Input
+	outputs  []*Output
+	progress func(Progress)
+	threads  int
+	nice     bool
+}
+
+// Input file with the options placed before its -i
+type Input struct {
+	path string
+	args []string
+}
+
+// Output file with the options placed before its path
+type Output struct {
+	path    string
+	args    []string
+	filters []string
+}
+
+// NewCommand starts an ffmpeg command that overwrites outputs
+func NewCommand() *Command {
+	return &Command{
+		global: []string{"-hide_banner", "-y"},
+	}
+}
+
+// Global adds an option before the inputs
+func (c *Command) Global(args ...string) *Command {
+	c.global = append(c.global, args...)
+	return c
+}
+
+// Threads limits the decoding, encoding and filter threads, 0 - ffmpeg decides
+func (c *Command) Threads(n int) *Command {
+	c.threads = n
+	return c
+}
+
+// Nice runs ffmpeg with the lowest CPU and IO priority through nice and ionice if they are found
+func (c *Command) Nice(on bool) *Command {
+	c.nice = on
+	return c
+}
+
+// Progress makes ffmpeg report progress to stdout, fn is called on every report
+func (c *Command) Progress(fn func(Progress)) *Command {
+	c.progress = fn
+	return c
+}
+
+func (c *Command) Input(path string) *Input {
+	in := &Input{path: path}
+	c.inputs = append(c.inputs, in)
+	return in
+}
+
+func (c *Command) Output(path string) *Output {
+	out := &Output{path: path}
+	c.outputs = append(c.outputs, out)
+	return out
+}
+
+func (i *Input) Option(key string, values ...string) *Input {
+	i.args = append(append(i.args, key), values...)
+	return i
+}
+
+// Framerate of an image sequence input
+func (i *Input) Framerate(fps int) *Input {
+	return i.Option("-framerate", fmt.Sprint(fps))
+}
+
+func (o *Output) Option(key string, values ...string) *Output {
+	o.args = append(append(o.args, key), values...)
+	return o
+}
+
+// Codec sets the codec for the stream type: v, a
+func (o *Output) Codec(stream, codec string) *Output {
+	return o.Option("-c:"+stream, codec)
+}
+
+func (o *Output) PixFmt(pixFmt string) *Output {
+	return o.Option("-pix_fmt", pixFmt)
+}
+
+// Metadata sets a container tag
+func (o *Output) Metadata(key, value string) *Output {
+	return o.Option("-metadata", key+"="+value)
+}
+
+// Filter appends a video filter to the output filter chain
+func (o *Output) Filter(filter string) *Output {
+	o.filters = append(o.filters, filter)
+	return o
+}
+
+// Frames limits the number of video frames written
+func (o *Output) Frames(n int) *Output {
+	return o.Option("-frames:v", fmt.Sprint(n))
+}
+
+// Args returns the arguments without the binary name
+func (c *Command) Args() []string {
+	args := append([]string{}, c.global...)
+	if c.progress != nil {
+		args = append(args, "-nostats", "-progress", "pipe:1")
+	}
+	// -threads is per input and per output
+	var threads []string
+	if c.threads > 0 {
+		threads = []string{"-threads", fmt.Sprint(c.threads)}
+		args = append(args, "-filter_threads", fmt.Sprint(c.threads))
+	}
+	for _, in := range c.inputs {
+		args = append(args, threads...)
+		args = append(args, in.args...)
+		args = append(args, "-i", in.path)
+	}
+	for _, out := range c.outputs {
+		args = append(args, threads...)
+		args = append(args, out.args...)
+		if len(out.filters) > 0 {
+			args = append(args, "-vf", strings.Join(out.filters, ","))
+		}
+		args = append(args, out.path)
+	}
+	return args
+}
+
+// String returns the command line quoted for a shell, for logs
+func (c *Command) String() string {
+	args := c.argv()
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$`*?;&|<>()[]{}") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted = append(quoted, a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// argv returns the binary and the arguments, with the priority wrappers in the nice mode.
+// Both wrappers exec ffmpeg, so cancelling kills ffmpeg itself.
+func (c *Command) argv() []string {
+	var argv []string
+	if c.nice {
+		if nice, err := exec.LookPath("nice"); err == nil {
+			argv = append(argv, nice, "-n", strconv.Itoa(NiceLevel))
+		}
+		if ionice, err := exec.LookPath("ionice"); err == nil {
+			argv = append(argv, ionice, "-c", "2", "-n", "7")
+		}
+	}
+	argv = append(argv, ffmpegBin)
+	return append(argv, c.Args()...)
+}
+
+// Cmd returns the exec command for the located ffmpeg binary
+func (c *Command) Cmd(ctx context.Context) *exec.Cmd {
+	logger.Log.Debugf("Running ffmpeg command: %s\n", c.String())
+	argv := c.argv()
+	return exec.CommandContext(ctx, argv[0], argv[1:]...)
+}
+
+// Run runs ffmpeg, the error includes the tail of stderr
+func (c *Command) Run(ctx context.Context) error {
+	cmd := c.Cmd(ctx)
+	var stderr tailBuffer
+	cmd.Stderr = &stderr
+	if c.progress == nil {
+		err := cmd.Run()
+		if err != nil {
+			return cmdError(ffmpegBin, err, stderr.Bytes())
+		}
+		return nil
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return cmdError(ffmpegBin, err, nil)
+	}
+	readProgress(stdout, c.progress)
+	err = cmd.Wait()
+	if err != nil {
+		return cmdError(ffmpegBin, err, stderr.Bytes())
+	}
+	return nil
+}
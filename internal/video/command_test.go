@@ -0,0 +1,62 @@
+package video
+
+import (
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCommandNice(t *testing.T) {
+	cmd := NewCommand().Nice(true)
+	cmd.Input("in.mov")
+	cmd.Output("out.mov")
+	argv := cmd.argv()
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip("nice is not found")
+	}
+	if len(argv) < 4 || argv[1] != "-n" || argv[2] != strconv.Itoa(NiceLevel) {
+		t.Fatalf("ffmpeg is not started through nice: %v", argv)
+	}
+	found := false
+	for _, a := range argv {
+		found = found || a == ffmpegBin
+	}
+	if !found {
+		t.Fatalf("no ffmpeg in %v", argv)
+	}
+
+	argv = NewCommand().argv()
+	if argv[0] != ffmpegBin {
+		t.Fatalf("ffmpeg is started through %s without the nice mode", argv[0])
+	}
+}
+
+func TestCommandArgsQuoting(t *testing.T) {
+	in := `/tmp/my frames/it's "out"_%08d.png`
+	out := `/tmp/out dir/reel "final" it's.mov`
+	title := `my file's "backup".tar`
+	cmd := NewCommand()
+	cmd.Input(in).Framerate(30)
+	cmd.Output(out).Codec("v", "libx264").Metadata("title", title)
+	want := []string{
+		"-hide_banner", "-y",
+		"-framerate", "30", "-i", in,
+		"-c:v", "libx264", "-metadata", "title=" + title, out,
+	}
+	if got := cmd.Args(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("args %q, want %q", got, want)
+	}
+
+	// the log line is quoted for a shell, every path is a single word
+	s := cmd.String()
+	for _, quoted := range []string{
+		`'/tmp/my frames/it'\''s "out"_%08d.png'`,
+		`'/tmp/out dir/reel "final" it'\''s.mov'`,
+	} {
+		if !strings.Contains(s, quoted) {
+			t.Fatalf("%s has no %s", s, quoted)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/1F47E/go-bitreel/internal/audio"
+)
+
+// Native backend, pure Go, no ffmpeg required.
+// Frames are stored as is in an AVI container with the PNG codec,
+// so the round trip is lossless and fast but the files are big.
+type Native struct{}
+
+func (Native) Name() string {
+	return BackendNative
+}
+
+// CanRead reports if the file is an AVI with PNG frames
+func (Native) CanRead(filename string) bool {
+	info, err := readAVI(filename, nil)
+	return err == nil && info.codec == aviCodec
+}
+
+func (Native) EncodeFrames(ctx context.Context, out, audioFile string, tags map[string]string, progress func(Progress)) error {
+	// frames are numbered from 1
+	var frames []string
+	for i := 1; ; i++ {
+		frame := fmt.Sprintf(framesPattern, i)
+		if _, err := os.Stat(frame); err != nil {
+			break
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames to encode")
+	}
+	first, err := os.Open(frames[0])
+	if err != nil {
+		return err
+	}
+	config, err := png.DecodeConfig(first)
+	first.Close()
+	if err != nil {
+		return fmt.Errorf("error reading frame %s: %w", frames[0], err)
+	}
+
+	var samples []int16
+	sampleRate := 0
+	if audioFile != "" {
+		samples, err = audio.ReadWav(audioFile)
+		if err != nil {
+			return fmt.Errorf("error reading audio: %w", err)
+		}
+		sampleRate = audio.SampleRate
+	}
+	perFrame := sampleRate / Framerate
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := newAVIWriter(f, config.Width, config.Height, Framerate, sampleRate, tags)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	for i, frame := range frames {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		data, err := os.ReadFile(frame)
+		if err != nil {
+			return err
+		}
+		err = w.WriteFrame(data)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", out, err)
+		}
+		// audio is interleaved with the frames it plays along
+		if len(samples) > 0 {
+			n := perFrame
+			if i == len(frames)-1 || n > len(samples) {
+				n = len(samples)
+			}
+			err = w.WriteAudio(samples[:n])
+			if err != nil {
+				return fmt.Errorf("error writing %s: %w", out, err)
+			}
+			samples = samples[n:]
+		}
+		if progress != nil {
+			progress(nativeProgress(i+1, start, i == len(frames)-1))
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", out, err)
+	}
+	return f.Close()
+}
+
+func (Native) ExtractFrames(ctx context.Context, filename, dir string, progress func(Progress)) error {
+	info, err := readAVI(filename, nil)
+	if err != nil {
+		return err
+	}
+	if info.codec != aviCodec {
+		return fmt.Errorf("unsupported codec %q in %s, use the ffmpeg backend", info.codec, filename)
+	}
+	start := time.Now()
+	frame := 0
+	_, err = readAVI(filename, func(id string, data []byte) error {
+		if id != aviVideoChunk {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		frame++
+		err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("out_%08d.png", frame)), data, 0644)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(nativeProgress(frame, start, false))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(nativeProgress(frame, start, true))
+	}
+	return nil
+}
+
+func (Native) ExtractAudio(ctx context.Context, filename, out string, sampleRate int) error {
+	var samples []int16
+	info, err := readAVI(filename, func(id string, data []byte) error {
+		if id != aviAudioChunk {
+			return nil
+		}
+		for i := 0; i+1 < len(data); i += 2 {
+			samples = append(samples, int16(uint16(data[i])|uint16(data[i+1])<<8))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if info.sampleRate == 0 {
+		return fmt.Errorf("no audio stream in %s", filename)
+	}
+	if info.sampleRate != sampleRate {
+		return fmt.Errorf("audio sample rate %d, expected %d", info.sampleRate, sampleRate)
+	}
+	return audio.WriteWav(out, samples)
+}
+
+func (Native) ExtractFirstFrame(ctx context.Context, filename, out string) error {
+	found := false
+	_, err := readAVI(filename, func(id string, data []byte) error {
+		if id != aviVideoChunk {
+			return nil
+		}
+		// the chunk is a png file already
+		if _, err := png.DecodeConfig(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("broken first frame: %w", err)
+		}
+		found = true
+		err := os.WriteFile(out, data, 0644)
+		if err != nil {
+			return err
+		}
+		return errStopWalk
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no frames in %s", filename)
+	}
+	return nil
+}
+
+func (Native) ProbeTags(ctx context.Context, filename string) (map[string]string, error) {
+	info, err := readAVI(filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	return info.tags, nil
+}
+
+func (Native) ProbeFrames(ctx context.Context, filename string) (int, error) {
+	info, err := readAVI(filename, nil)
+	if err != nil {
+		return 0, err
+	}
+	return info.frames, nil
+}
+
+// FrameSize returns the size of the frame chunk with its index entry, frames are stored as is
+func (Native) FrameSize(ctx context.Context, frame string) (int64, error) {
+	fi, err := os.Stat(frame)
+	if err != nil {
+		return 0, err
+	}
+	// chunk header, word alignment and the idx1 entry
+	return int64(len(aviChunk(aviVideoChunk, nil))) + fi.Size() + 1 + 16, nil
+}
+
+func nativeProgress(frame int, start time.Time, done bool) Progress {
+	p := Progress{Frame: frame, Done: done}
+	if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+		p.FPS = float64(frame) / elapsed
+		p.Speed = p.FPS / Framerate
+	}
+	return p
+}
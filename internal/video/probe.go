@@ -0,0 +1,213 @@
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EnvFFmpeg overrides the ffmpeg binary, the --ffmpeg flag wins over it
+const EnvFFmpeg = "BITREEL_FFMPEG"
+
+// oldest ffmpeg tested with the encoding settings
+const minMajorVersion = 4
+
+// paths to the binaries, ffprobe is looked up next to ffmpeg first
+var (
+	ffmpegPath string // from the command line
+	ffmpegBin  = "ffmpeg"
+	ffprobeBin = "ffprobe"
+)
+
+// SetFFmpeg sets the ffmpeg binary path from the command line
+func SetFFmpeg(path string) {
+	ffmpegPath = path
+}
+
+// Locate finds ffmpeg and ffprobe binaries.
+// Path from the command line goes first, then the env var and then PATH.
+func Locate() error {
+	path := ffmpegPath
+	if path == "" {
+		path = os.Getenv(EnvFFmpeg)
+	}
+	if path == "" {
+		path = "ffmpeg"
+	}
+	bin, err := exec.LookPath(path)
+	if err != nil {
+		return fmt.Errorf("ffmpeg not found (%s): install it (brew install ffmpeg), or set the path with --ffmpeg or %s", path, EnvFFmpeg)
+	}
+	ffmpegBin = bin
+
+	probe := filepath.Join(filepath.Dir(bin), "ffprobe"+filepath.Ext(bin))
+	if _, err := os.Stat(probe); err == nil {
+		ffprobeBin = probe
+	} else if bin, err := exec.LookPath("ffprobe"); err == nil {
+		ffprobeBin = bin
+	}
+	return nil
+}
+
+// Capabilities of the located ffmpeg build
+type Capabilities struct {
+	Path    string
+	Version string
+	// 0 for git builds, they are not checked
+	Major, Minor int
+	Encoders     map[string]bool
+	PixFmts      map[string]bool
+}
+
+var versionRe = regexp.MustCompile(`^ffmpeg version n?(\d+)\.(\d+)`)
+
+// Probe locates ffmpeg and runs it to get the version, encoders and pixel formats
+func Probe(ctx context.Context) (*Capabilities, error) {
+	err := Locate()
+	if err != nil {
+		return nil, err
+	}
+	caps := &Capabilities{
+		Path:     ffmpegBin,
+		Encoders: make(map[string]bool),
+		PixFmts:  make(map[string]bool),
+	}
+
+	out, err := output(ctx, ffmpegBin, "-hide_banner", "-version")
+	if err != nil {
+		return nil, err
+	}
+	// ffmpeg version 6.1.1 Copyright (c) 2000-2023 the FFmpeg developers
+	line, _, _ := strings.Cut(string(out), "\n")
+	if f := strings.Fields(line); len(f) >= 3 {
+		caps.Version = f[2]
+	}
+	if m := versionRe.FindStringSubmatch(line); m != nil {
+		caps.Major, _ = strconv.Atoi(m[1])
+		caps.Minor, _ = strconv.Atoi(m[2])
+	}
+
+	// V....D prores    Apple ProRes (iCodec Pro) (codec prores)
+	out, err = output(ctx, ffmpegBin, "-hide_banner", "-encoders")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range parseList(out) {
+		caps.Encoders[name] = true
+	}
+
+	// IO... yuv422p10le    3    20    10-10-10
+	out, err = output(ctx, ffmpegBin, "-hide_banner", "-pix_fmts")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range parseList(out) {
+		caps.PixFmts[name] = true
+	}
+	return caps, nil
+}
+
+// Require fails if the build is too old or misses any of the encoders or pixel formats
+func (c *Capabilities) Require(encoders []string, pixFmts []string) error {
+	if c.Major > 0 && c.Major < minMajorVersion {
+		return fmt.Errorf("ffmpeg %s at %s is too old, version %d.0 or newer is required", c.Version, c.Path, minMajorVersion)
+	}
+	var missing []string
+	for _, e := range encoders {
+		if !c.Encoders[e] {
+			missing = append(missing, "encoder "+e)
+		}
+	}
+	for _, p := range pixFmts {
+		// short names are aliases for the native endian format
+		if !c.PixFmts[p] && !c.PixFmts[p+"le"] && !c.PixFmts[p+"be"] {
+			missing = append(missing, "pixel format "+p)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("ffmpeg %s at %s has no %s, install a full build or set the path with --ffmpeg", c.Version, c.Path, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// parseList reads names from the second column of the listing after the ---- separator
+func parseList(out []byte) []string {
+	var names []string
+	started := false
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !started {
+			started = strings.HasPrefix(line, "---")
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) >= 2 {
+			names = append(names, f[1])
+		}
+	}
+	return names
+}
+
+// output runs the command and returns stdout, the error includes stderr
+func output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, cmdError(name, err, stderr.Bytes())
+	}
+	return out, nil
+}
+
+// keep the tail of stderr, ffmpeg prints the reason last
+const (
+	stderrLines = 10
+	stderrBytes = 64 << 10
+)
+
+// tailBuffer keeps the last stderrBytes written,
+// ffmpeg prints the stats line for every frame on long runs
+type tailBuffer struct {
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrBytes {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-stderrBytes:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) Bytes() []byte {
+	return t.buf
+}
+
+func cmdError(name string, err error, stderr []byte) error {
+	// stats lines are separated by \r, skip them
+	text := strings.ReplaceAll(string(stderr), "\r", "\n")
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "frame=") || strings.HasPrefix(line, "size=") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > stderrLines {
+		lines = lines[len(lines)-stderrLines:]
+	}
+	msg := strings.TrimSpace(strings.Join(lines, "\n"))
+	if msg == "" {
+		return fmt.Errorf("%s: %w", filepath.Base(name), err)
+	}
+	return fmt.Errorf("%s: %w\n%s", filepath.Base(name), err, msg)
+}
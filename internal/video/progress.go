@@ -0,0 +1,92 @@
+package video
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress reported by ffmpeg with -progress
+type Progress struct {
+	Frame int
+	FPS   float64
+	// encoding speed relative to the video duration, 0 if unknown
+	Speed   float64
+	OutTime time.Duration
+	Done    bool
+}
+
+// readProgress parses key=value blocks, every block ends with the progress key
+//
+//	frame=120
+//	fps=31.42
+//	out_time_us=4000000
+//	speed=1.05x
+//	progress=continue
+func readProgress(r io.Reader, fn func(Progress)) {
+	var p Progress
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "frame":
+			p.Frame, _ = strconv.Atoi(value)
+		case "fps":
+			p.FPS, _ = strconv.ParseFloat(value, 64)
+		case "out_time_us":
+			us, _ := strconv.ParseInt(value, 10, 64)
+			p.OutTime = time.Duration(us) * time.Microsecond
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			p.Done = value == "end"
+			fn(p)
+		}
+	}
+	// drain so ffmpeg never blocks on a full pipe
+	_, _ = io.Copy(io.Discard, r)
+}
+
+// ProbeFrames returns the number of video frames from the container,
+// falls back to duration * framerate, 0 if unknown
+func (FFmpeg) ProbeFrames(ctx context.Context, filename string) (int, error) {
+	out, err := output(ctx, ffprobeBin, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=nb_frames,duration,avg_frame_rate", "-of", "default=noprint_wrappers=1", filename)
+	if err != nil {
+		return 0, err
+	}
+	var frames int
+	var duration, rate float64
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "nb_frames":
+			frames, _ = strconv.Atoi(value)
+		case "duration":
+			duration, _ = strconv.ParseFloat(value, 64)
+		case "avg_frame_rate":
+			// 30/1
+			num, den, _ := strings.Cut(value, "/")
+			n, _ := strconv.ParseFloat(num, 64)
+			d, err := strconv.ParseFloat(den, 64)
+			if err == nil && d > 0 {
+				rate = n / d
+			}
+		}
+	}
+	if frames > 0 {
+		return frames, nil
+	}
+	if duration > 0 && rate > 0 {
+		return int(duration*rate + 0.5), nil
+	}
+	return 0, fmt.Errorf("frames count is unknown")
+}
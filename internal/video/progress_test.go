@@ -0,0 +1,55 @@
+package video
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProgress(t *testing.T) {
+	// as printed by ffmpeg -progress pipe:1, with the keys the parser skips
+	out := strings.Join([]string{
+		"frame=120",
+		"fps=31.42",
+		"stream_0_0_q=-1.0",
+		"bitrate=N/A",
+		"out_time_us=4000000",
+		"speed=1.05x",
+		"progress=continue",
+		"frame=240",
+		"fps= 30.00",
+		"out_time_us=8000000",
+		"speed=N/A",
+		"progress=continue",
+		"frame=300",
+		"fps=29.50",
+		"out_time_us=10000000",
+		"speed=0.98x",
+		"progress=end",
+		"",
+	}, "\n")
+	want := []Progress{
+		{Frame: 120, FPS: 31.42, Speed: 1.05, OutTime: 4 * time.Second},
+		{Frame: 240, FPS: 30, Speed: 0, OutTime: 8 * time.Second},
+		{Frame: 300, FPS: 29.5, Speed: 0.98, OutTime: 10 * time.Second, Done: true},
+	}
+	var got []Progress
+	readProgress(strings.NewReader(out), func(p Progress) {
+		got = append(got, p)
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("progress %+v, want %+v", got, want)
+	}
+}
+
+func TestReadProgressPartial(t *testing.T) {
+	// killed before the block ends, nothing is reported
+	var got []Progress
+	readProgress(strings.NewReader("frame=10\nfps=25\n"), func(p Progress) {
+		got = append(got, p)
+	})
+	if len(got) != 0 {
+		t.Fatalf("reported %+v for a block without progress", got)
+	}
+}
@@ -2,29 +2,145 @@ package video
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os/exec"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
-	cfg "github.com/1F47E/go-bitreel/internal/config"
 	"github.com/1F47E/go-bitreel/internal/logger"
 )
 
+// frames written by the encoder, see storage.SaveFrame
+const framesPattern = "tmp/out/out_%08d.png"
+
+// Framerate of the encoded video
+const Framerate = 30
+
+// NiceLevel is the nice value of bitreel and ffmpeg in the nice mode, 19 is the lowest priority
+const NiceLevel = 10
+
+// FFmpeg backend, any container and codec ffmpeg supports
+type FFmpeg struct {
+	// threads per ffmpeg run, 0 - ffmpeg decides
+	Threads int
+	// run ffmpeg with the lower CPU and IO priority
+	Nice bool
+}
+
+// command starts an ffmpeg command with the resource settings
+func (f FFmpeg) command() *Command {
+	return NewCommand().Threads(f.Threads).Nice(f.Nice)
+}
+
+func (FFmpeg) Name() string {
+	return BackendFFmpeg
+}
+
 // call ffmpeg to decode the video into frames
-func ExtractFrames(ctx context.Context, filename, dir string) error {
-	framesPath := dir + "/out_%08d.png"
-	cmdStr := fmt.Sprintf("ffmpeg -y -i %s %s", filename, framesPath)
-	cmdList := strings.Split(cmdStr, " ")
-	logger.Log.Debugf("Running ffmpeg command: %s\n", cmdStr)
-	cmd := exec.CommandContext(ctx, cmdList[0], cmdList[1:]...)
-	return cmd.Run()
+// progress is optional
+func (f FFmpeg) ExtractFrames(ctx context.Context, filename, dir string, progress func(Progress)) error {
+	cmd := f.command().Progress(progress)
+	cmd.Input(filename)
+	// frames are black and white, the chroma is not needed
+	cmd.Output(filepath.Join(dir, "out_%08d.png")).PixFmt("gray")
+	return cmd.Run(ctx)
 }
 
 // call ffmpeg to encode frames into video
-func EncodeFrames(ctx context.Context) error {
-	cmdStr := "ffmpeg -y -framerate 30 -i tmp/out/out_%08d.png -c:v prores -profile:v 3 -pix_fmt yuv422p10 " + cfg.PathVideoOut
-	cmdList := strings.Split(cmdStr, " ")
-	logger.Log.Debugf("Running ffmpeg command: %s\n", cmdStr)
-	cmd := exec.CommandContext(ctx, cmdList[0], cmdList[1:]...)
-	return cmd.Run()
+// audio is an optional wav file to mux as the audio track
+// tags are written to the container metadata
+// progress is optional
+func (f FFmpeg) EncodeFrames(ctx context.Context, out, audio string, tags map[string]string, progress func(Progress)) error {
+	cmd := f.command().Progress(progress)
+	cmd.Input(framesPattern).Framerate(Framerate)
+	if audio != "" {
+		cmd.Input(audio)
+	}
+	o := cmd.Output(out)
+	if audio != "" {
+		// PCM so the modem signal is not touched by a lossy codec
+		o.Codec("a", "pcm_s16le")
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o.Metadata(k, tags[k])
+	}
+	videoCodec(o)
+	return cmd.Run(ctx)
+}
+
+// videoCodec sets the codec of the encoded video
+func videoCodec(o *Output) {
+	// prores has no gray format, gray frames get the neutral chroma
+	o.Codec("v", "prores").Option("-profile:v", "3").PixFmt("yuv422p10")
+}
+
+// FrameSize encodes the frame alone and returns the size of the video,
+// the container overhead is counted too, so a volume stays under the limit
+func (f FFmpeg) FrameSize(ctx context.Context, frame string) (int64, error) {
+	dir, err := os.MkdirTemp("", "bitreel-frame-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "frame.mov")
+	cmd := f.command()
+	cmd.Input(frame)
+	videoCodec(cmd.Output(out))
+	err = cmd.Run(ctx)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(out)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// call ffmpeg to extract the audio track as 48kHz mono wav
+func (f FFmpeg) ExtractAudio(ctx context.Context, filename, out string, sampleRate int) error {
+	cmd := f.command()
+	cmd.Input(filename)
+	cmd.Output(out).
+		Option("-vn").
+		Option("-ac", "1").
+		Option("-ar", fmt.Sprint(sampleRate)).
+		Codec("a", "pcm_s16le").
+		Option("-f", "wav")
+	return cmd.Run(ctx)
+}
+
+// call ffmpeg to extract only the first frame of the video
+func (f FFmpeg) ExtractFirstFrame(ctx context.Context, filename, out string) error {
+	cmd := f.command()
+	cmd.Input(filename)
+	cmd.Output(out).Frames(1).PixFmt("gray")
+	return cmd.Run(ctx)
+}
+
+// call ffprobe to read the container metadata tags
+func (FFmpeg) ProbeTags(ctx context.Context, filename string) (map[string]string, error) {
+	args := []string{"-v", "error", "-show_entries", "format_tags", "-of", "json", filename}
+	logger.Log.Debugf("Running ffprobe command: ffprobe %s\n", strings.Join(args, " "))
+	out, err := output(ctx, ffprobeBin, args...)
+	if err != nil {
+		return nil, err
+	}
+	var probe struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+	err = json.Unmarshal(out, &probe)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ffprobe output: %w", err)
+	}
+	return probe.Format.Tags, nil
 }
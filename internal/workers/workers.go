@@ -3,6 +3,8 @@ package workers
 import (
 	"context"
 	"fmt"
+	"image"
+	"os"
 	"time"
 
 	cfg "github.com/1F47E/go-bitreel/internal/config"
@@ -18,96 +20,226 @@ type Worker struct {
 	encodingCh chan job.JobEnc
 	decodingCh chan job.JobDec
 	encoder    *encoder.FrameEncoder
+	// reel header and frames index from outside of the frames, optional
+	manifest *meta.Manifest
+	// other frame geometries for captures, picked by the aspect ratio
+	geometries []*encoder.FrameEncoder
 }
 
-func NewWorker(ctx context.Context) *Worker {
+func NewWorker(ctx context.Context, enc *encoder.FrameEncoder) *Worker {
 	return &Worker{
 		ctx:        ctx,
 		encodingCh: make(chan job.JobEnc),
 		decodingCh: make(chan job.JobDec),
-		encoder:    encoder.NewFrameEncoder(cfg.SizeFrameWidth, cfg.SizeFrameHeight),
+		encoder:    enc,
 	}
 }
 
-func (w *Worker) WorkerEncode(i int, jobs <-chan job.JobEnc) {
+// SetManifest sets the manifest to verify and restore frames with a broken header
+func (w *Worker) SetManifest(m *meta.Manifest) {
+	w.manifest = m
+}
+
+// AddGeometry adds a frame geometry the captures can have, like the paper page
+func (w *Worker) AddGeometry(enc *encoder.FrameEncoder) {
+	w.geometries = append(w.geometries, enc)
+}
+
+// encoderFor picks the geometry with the aspect ratio closest to the capture
+func (w *Worker) encoderFor(file string) *encoder.FrameEncoder {
+	if len(w.geometries) == 0 {
+		return w.encoder
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return w.encoder
+	}
+	defer f.Close()
+	img, _, err := image.DecodeConfig(f)
+	if err != nil || img.Height == 0 {
+		return w.encoder
+	}
+	return encoder.Closest(append([]*encoder.FrameEncoder{w.encoder}, w.geometries...), img.Width, img.Height)
+}
+
+// WorkerEncode encodes the jobs into frame files until the channel is closed.
+// Returns the first error with the frame number, the caller cancels the other workers.
+func (w *Worker) WorkerEncode(i int, jobs <-chan job.JobEnc) error {
 	log := logger.Log.WithField("scope", fmt.Sprintf("WorkerEncode #%d", i))
 	name := fmt.Sprintf("WorkerEncode #%d", i)
 	log.Debugf("%s started\n", name)
 	defer log.Debugf("%s finished\n", name)
 
-	var err error
 	for {
 		select {
 		case <-w.ctx.Done():
-			return
+			return nil
 		case j, ok := <-jobs:
 			if !ok {
-				return
+				return nil
 			}
 			log.Debugf("%s got job %s\n", name, j.Print())
 
-			// Encoding bits to image - about 1.5s
+			// Encoding bits to image - about 30ms on 4k
 			now := time.Now()
 			log.Debugf("%s Frame start: %d\n", name, j.FrameNum)
-			img := w.encoder.EncodeFrame(j.Buffer, j.Metadata)
+			img, err := w.encoder.EncodeFrame(j.Buffer, j.Metadata)
+			if err != nil {
+				return fmt.Errorf("frame %d: %w", j.Metadata.Frame(), err)
+			}
 			log.Debugf("%s Frame done. Took time: %s\n", name, time.Since(now))
 
-			// Saving image to file - about 5s
+			// Saving image to file, png compression takes the most
 			now = time.Now()
 			log.Debugf("%s Save start: %d\n", name, j.FrameNum)
 			err = storage.SaveFrame(j.FrameNum, img)
 			if err != nil {
-				log.Fatalf("\n%s Error saving frame: %v\n", name, err)
+				return fmt.Errorf("frame %d: error saving frame: %w", j.Metadata.Frame(), err)
 			}
+			// the image is saved, the next frame reuses it
+			w.encoder.ReleaseFrame(img)
 			log.Debugf("%s Saving done. Took time: %s\n", name, time.Since(now))
 		}
 	}
 }
 
-func (w *Worker) WorkerDecode(id int, fCh <-chan job.JobDec, resChs []chan job.JobDecRes) {
+// WorkerDecode decodes the frames into the result channel, results come out of order with the frame index.
+// Returns the first error with the frame file, the caller cancels the other workers.
+func (w *Worker) WorkerDecode(id int, fCh <-chan job.JobDec, resCh chan<- job.JobDecRes) error {
 	log := logger.Log.WithField("scope", fmt.Sprintf("WorkerDecode #%d", id))
 	log.Debug("started")
 	defer log.Debug("finished")
 	for {
 		select {
 		case <-w.ctx.Done():
-			return
+			return nil
 		case frame, ok := <-fCh:
 			if !ok {
-				return
+				return nil
 			}
 			file := frame.File
 			log.Debugf(" got %d-%s\n", frame.Idx, file)
 
 			// decode frame file into bytes
-			frameBytes, fileBytesCnt := w.encoder.DecodeFrame(file)
+			frameBytes, fileBytesCnt, corrected, err := w.encoder.DecodeFrame(file)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
 			log.Debugf("decoded %s\n", file)
 
 			// split frameBytes to header and data
+			if fileBytesCnt < cfg.SizeMetadata {
+				log.Warnf("frame is empty or unreadable %s", file)
+				if !w.send(resCh, job.JobDecRes{Idx: frame.Idx}) {
+					return nil
+				}
+				continue
+			}
 			fileBytesCnt -= cfg.SizeMetadata
 			header := frameBytes[:cfg.SizeMetadata]
+			data := frameBytes[cfg.SizeMetadata : cfg.SizeMetadata+fileBytesCnt]
 			m, err := meta.Parse(header)
 			if err != nil {
-				log.Warnf("\n!!! metadata broken in file %s: %s\n", file, err)
+				log.Warnf("metadata broken in file %s: %s", file, err)
+				// frames come in order, settings and checksum are in the manifest
+				if w.manifest != nil {
+					if fm, ok := w.manifest.Frame(frame.Idx + 1); ok {
+						// the data size is in the broken header, all the cells are read
+						if fm.Size() <= len(data) {
+							data = data[:fm.Size()]
+						}
+						encoder.Restore(data, fm)
+						m = fm
+						log.Warnf("using manifest metadata for file %s", file)
+					}
+				}
 			}
 			log.Debugf("parsed metadata in %s\n", file)
-			data := frameBytes[cfg.SizeMetadata : cfg.SizeMetadata+fileBytesCnt]
 
 			// validate checksum
 			isValid, err := m.Validate(data)
 			if err != nil {
-				log.Warnf("\n!!! checksum validation failed in file %s: %s\n", file, err)
+				log.Warnf("checksum validation failed in file %s: %s", file, err)
 			}
 			if !isValid {
-				log.Warnf("\n!!! frame checksum and metadata checksum mismatch in file %s\n", file)
+				log.Warnf("frame checksum and metadata checksum mismatch in file %s", file)
 			}
 			log.Debugf("validated %s\n", file)
-			resChs[frame.Idx] <- job.JobDecRes{
-				Data: data,
-				Meta: m,
+			res := job.JobDecRes{
+				Idx:       frame.Idx,
+				Data:      data,
+				Meta:      m,
+				FrameSize: len(frameBytes) - cfg.SizeMetadata,
+				Valid:     isValid,
+				Corrected: corrected,
+			}
+			if !w.send(resCh, res) {
+				return nil
+			}
+
+			log.Debugf("sent res %s\n", file)
+		}
+	}
+}
+
+// WorkerCapture decodes frames captured by a camera or a screen recorder.
+// Captures come in any order and the same frame can be captured many times,
+// results are sent to a single channel with the frame number in the metadata.
+// Returns the first error with the capture file, the caller cancels the other workers.
+func (w *Worker) WorkerCapture(id int, fCh <-chan job.JobDec, resCh chan<- job.JobDecRes) error {
+	log := logger.Log.WithField("scope", fmt.Sprintf("WorkerCapture #%d", id))
+	log.Debug("started")
+	defer log.Debug("finished")
+	for {
+		select {
+		case <-w.ctx.Done():
+			return nil
+		case frame, ok := <-fCh:
+			if !ok {
+				return nil
 			}
+			file := frame.File
+			log.Debugf(" got %d-%s\n", frame.Idx, file)
 
+			var res job.JobDecRes
+			frameBytes, fileBytesCnt, sharpness, err := w.encoderFor(file).DecodeCapture(file)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			if fileBytesCnt >= cfg.SizeMetadata {
+				m, err := meta.Parse(frameBytes[:cfg.SizeMetadata])
+				if err != nil {
+					log.Debugf("metadata broken in capture %s: %s\n", file, err)
+				}
+				// data size is in the header, a broken one may have any
+				end := cfg.SizeMetadata + m.Size()
+				if end > fileBytesCnt {
+					end = fileBytesCnt
+				}
+				data := frameBytes[cfg.SizeMetadata:end]
+				isValid, _ := m.Validate(data)
+				res = job.JobDecRes{
+					Data:      data,
+					Meta:      m,
+					FrameSize: len(frameBytes) - cfg.SizeMetadata,
+					Valid:     err == nil && isValid,
+					Sharpness: sharpness,
+				}
+			}
+			if !w.send(resCh, res) {
+				return nil
+			}
 			log.Debugf("sent res %s\n", file)
 		}
 	}
 }
+
+// send blocks until the result is taken, false if cancelled
+func (w *Worker) send(resCh chan<- job.JobDecRes, res job.JobDecRes) bool {
+	select {
+	case <-w.ctx.Done():
+		return false
+	case resCh <- res:
+		return true
+	}
+}